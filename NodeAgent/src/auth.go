@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxClockSkew is how old (or early) a signed request may be; older requests are replays
+const maxClockSkew = 60 * time.Second
+
+/**
+Requests are signed by the watcher with the shared TOKEN:
+X-Agent-Signature = hex(HMAC-SHA256(TOKEN, method \n path?query \n X-Agent-Timestamp \n X-Agent-Nonce \n body))
+The timestamp (unix seconds) rejects requests older than maxClockSkew, and the nonces seen within that window
+are remembered, so a signed request is accepted only once
+**/
+func signature(token string, method string, uri string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// seenNonces maps the nonces of accepted requests to when they can be forgotten
+var seenNonces = map[string]time.Time{}
+var nonceMX sync.Mutex
+
+// useNonce records a nonce, false if it was already used; nonces older than twice maxClockSkew are dropped
+func useNonce(nonce string) bool {
+	nonceMX.Lock()
+	defer nonceMX.Unlock()
+	now := time.Now()
+	for seen, expires := range seenNonces {
+		if now.After(expires) {
+			delete(seenNonces, seen)
+		}
+	}
+	if _, ok := seenNonces[nonce]; ok {
+		return false
+	}
+	seenNonces[nonce] = now.Add(2 * maxClockSkew)
+	return true
+}
+
+func authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		timestamp := r.Header.Get("X-Agent-Timestamp")
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		skew := time.Since(time.Unix(seconds, 0))
+		if skew > maxClockSkew || skew < -maxClockSkew {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		nonce := r.Header.Get("X-Agent-Nonce")
+		if len(nonce) < 16 || len(nonce) > 64 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		expected := signature(Config().TOKEN, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+		if Config().TOKEN == "" || !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Agent-Signature"))) {
+			fmt.Printf("Rejected %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// only once the signature is checked, so unsigned requests cannot fill the cache
+		if !useNonce(nonce) {
+			fmt.Printf("Rejected replayed %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
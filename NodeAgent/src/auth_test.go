@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedRequest(method string, uri string, timestamp int64, nonce string) *http.Request {
+	req := httptest.NewRequest(method, uri, nil)
+	stamp := strconv.FormatInt(timestamp, 10)
+	req.Header.Set("X-Agent-Timestamp", stamp)
+	req.Header.Set("X-Agent-Nonce", nonce)
+	req.Header.Set("X-Agent-Signature", signature(Config().TOKEN, method, uri, stamp, nonce, nil))
+	return req
+}
+
+func TestAuthenticate(t *testing.T) {
+	ENVIR = "dev"
+	handler := authenticate(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	now := time.Now().Unix()
+	forged := signedRequest("POST", "/fence", now, "0123456789abcdef0123456789abcdef")
+	forged.Header.Set("X-Agent-Signature", signature("another token", "POST", "/fence", strconv.FormatInt(now, 10), "0123456789abcdef0123456789abcdef", nil))
+	renamed := signedRequest("POST", "/stop", now, "1123456789abcdef0123456789abcdef")
+	renamed.Header.Set("X-Agent-Nonce", "2123456789abcdef0123456789abcdef")
+
+	cases := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"signed", signedRequest("POST", "/fence", now, "3123456789abcdef0123456789abcdef"), http.StatusOK},
+		{"replayed", signedRequest("POST", "/fence", now, "3123456789abcdef0123456789abcdef"), http.StatusUnauthorized},
+		{"another nonce", signedRequest("POST", "/fence", now, "4123456789abcdef0123456789abcdef"), http.StatusOK},
+		{"nonce not signed", renamed, http.StatusUnauthorized},
+		{"short nonce", signedRequest("POST", "/fence", now, "5123"), http.StatusUnauthorized},
+		{"wrong token", forged, http.StatusUnauthorized},
+		{"too old", signedRequest("POST", "/fence", now-int64(2*maxClockSkew/time.Second), "6123456789abcdef0123456789abcdef"), http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		handler(recorder, c.req)
+		if recorder.Code != c.status {
+			t.Errorf("%s: status %d, expected %d", c.name, recorder.Code, c.status)
+		}
+	}
+}
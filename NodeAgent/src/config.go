@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+type Configuration struct {
+	LISTEN                string // address the agent listens on, e.g. 0.0.0.0:9955
+	TOKEN                 string // shared secret that signs requests; same as AGENT_TOKEN of the watcher
+	RPC_ENDPOINT          string // local node http endpoint, e.g. http://127.0.0.1:9933
+	SERVICE               string // systemd unit of the node
+	DATA_DIR              string // node base path, for disk checks
+	MIN_FREE_DISK_PERCENT float64
+	MIN_AVAILABLE_MEMORY  uint64 // in bytes
+	MAX_LOG_LINES         int
+}
+
+var onceConf sync.Once
+var ENVIR string = "prod"
+var configuration Configuration
+
+func Config() Configuration {
+	onceConf.Do(loadConfig)
+	return configuration
+}
+
+func loadConfig() {
+	configuration = Configuration{}
+	var fileName string
+	if ENVIR == "dev" {
+		fileName = fmt.Sprintf("./%s_agent_config.json", ENVIR)
+	} else {
+		fileName = fmt.Sprintf("/home/ubuntu/%s_agent_config.json", ENVIR)
+	}
+	raw, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		panic(err)
+	}
+	if err = json.Unmarshal(raw, &configuration); err != nil {
+		panic(err)
+	}
+}
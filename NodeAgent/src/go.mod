@@ -0,0 +1,3 @@
+module movragent
+
+go 1.15
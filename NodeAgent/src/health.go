@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type RPCHealth struct {
+	OK        bool   `json:"ok"`
+	Best      int    `json:"best"` // best block number
+	Peers     int    `json:"peers"`
+	IsSyncing bool   `json:"isSyncing"`
+	Error     string `json:"error,omitempty"`
+}
+
+type DiskHealth struct {
+	Path        string  `json:"path"`
+	TotalBytes  uint64  `json:"totalBytes"`
+	FreeBytes   uint64  `json:"freeBytes"`
+	FreePercent float64 `json:"freePercent"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type MemoryHealth struct {
+	TotalBytes     uint64 `json:"totalBytes"`
+	AvailableBytes uint64 `json:"availableBytes"`
+	Error          string `json:"error,omitempty"`
+}
+
+type ProcessHealth struct {
+	Unit   string `json:"unit"`
+	Active bool   `json:"active"`
+	State  string `json:"state"` // output of systemctl is-active
+}
+
+// Health is the local view of the node; Healthy is false if any check fails
+type Health struct {
+	Healthy   bool          `json:"healthy"`
+	Problems  []string      `json:"problems"`
+	RPC       RPCHealth     `json:"rpc"`
+	Disk      DiskHealth    `json:"disk"`
+	Memory    MemoryHealth  `json:"memory"`
+	Process   ProcessHealth `json:"process"`
+	CheckedAt int64         `json:"checkedAt"`
+}
+
+func checkHealth() *Health {
+	health := &Health{
+		RPC:       checkRPC(),
+		Disk:      checkDisk(Config().DATA_DIR),
+		Memory:    checkMemory(),
+		Process:   checkProcess(Config().SERVICE),
+		CheckedAt: time.Now().Unix(),
+		Problems:  []string{},
+	}
+	if !health.Process.Active {
+		health.Problems = append(health.Problems, fmt.Sprintf("%s is %s", health.Process.Unit, health.Process.State))
+	}
+	if !health.RPC.OK {
+		health.Problems = append(health.Problems, "rpc: "+health.RPC.Error)
+	} else if health.RPC.IsSyncing {
+		health.Problems = append(health.Problems, "node is syncing")
+	}
+	if health.Disk.Error != "" {
+		health.Problems = append(health.Problems, "disk: "+health.Disk.Error)
+	} else if health.Disk.FreePercent < Config().MIN_FREE_DISK_PERCENT {
+		health.Problems = append(health.Problems, fmt.Sprintf("disk %.1f%% free", health.Disk.FreePercent))
+	}
+	if health.Memory.Error != "" {
+		health.Problems = append(health.Problems, "memory: "+health.Memory.Error)
+	} else if health.Memory.AvailableBytes < Config().MIN_AVAILABLE_MEMORY {
+		health.Problems = append(health.Problems, fmt.Sprintf("%d bytes of memory available", health.Memory.AvailableBytes))
+	}
+	health.Healthy = len(health.Problems) == 0
+	return health
+}
+
+func rpcCall(method string, params []interface{}, out interface{}) error {
+	request, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(Config().RPC_ENDPOINT, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	answer := struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return err
+	}
+	if answer.Error != nil {
+		return fmt.Errorf("%s: %s", method, answer.Error.Message)
+	}
+	return json.Unmarshal(answer.Result, out)
+}
+
+func checkRPC() RPCHealth {
+	health := RPCHealth{}
+	system := struct {
+		Peers     int  `json:"peers"`
+		IsSyncing bool `json:"isSyncing"`
+	}{}
+	if err := rpcCall("system_health", []interface{}{}, &system); err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	header := struct {
+		Number string `json:"number"`
+	}{}
+	if err := rpcCall("chain_getHeader", []interface{}{}, &header); err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	best, err := strconv.ParseInt(strings.TrimPrefix(header.Number, "0x"), 16, 64)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.OK = true
+	health.Best = int(best)
+	health.Peers = system.Peers
+	health.IsSyncing = system.IsSyncing
+	return health
+}
+
+func checkDisk(path string) DiskHealth {
+	health := DiskHealth{Path: path}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.TotalBytes = stat.Blocks * uint64(stat.Bsize)
+	health.FreeBytes = stat.Bavail * uint64(stat.Bsize)
+	if health.TotalBytes > 0 {
+		health.FreePercent = 100 * float64(health.FreeBytes) / float64(health.TotalBytes)
+	}
+	return health
+}
+
+func checkMemory() MemoryHealth {
+	health := MemoryHealth{}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text()) // e.g. "MemAvailable:   123456 kB"
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			health.TotalBytes = kb * 1024
+		case "MemAvailable:":
+			health.AvailableBytes = kb * 1024
+		}
+	}
+	return health
+}
+
+func checkProcess(unit string) ProcessHealth {
+	output, _ := exec.Command("systemctl", "is-active", unit).Output() // non-zero exit for inactive units
+	state := strings.TrimSpace(string(output))
+	if state == "" {
+		state = "unknown"
+	}
+	return ProcessHealth{Unit: unit, Active: state == "active", State: state}
+}
+
+// hasKey asks the node whether its keystore holds the private key of a public key (author_hasKey)
+func hasKey(publicKey string, keyType string) (bool, error) {
+	var has bool
+	err := rpcCall("author_hasKey", []interface{}{publicKey, keyType}, &has)
+	return has, err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+movragent runs beside a collator node and lets the watcher check and control it:
+health, restart, stop, fence (stop and mask the unit), unfence, key presence and log tail.
+Every request must be signed with the shared TOKEN (see auth.go)
+**/
+func main() {
+	if len(os.Args) > 1 {
+		ENVIR = os.Args[1]
+	}
+
+	http.HandleFunc("/health", authenticate(only("GET", handleHealth)))
+	http.HandleFunc("/restart", authenticate(only("POST", unitHandler("restart"))))
+	http.HandleFunc("/stop", authenticate(only("POST", unitHandler("stop"))))
+	http.HandleFunc("/fence", authenticate(only("POST", handleFence)))
+	http.HandleFunc("/unfence", authenticate(only("POST", handleUnfence)))
+	http.HandleFunc("/keys", authenticate(only("GET", handleKeys)))
+	http.HandleFunc("/logs", authenticate(only("GET", handleLogs)))
+
+	fmt.Printf("Agent for %s listening on %s\n", Config().SERVICE, Config().LISTEN)
+	server := &http.Server{
+		Addr:         Config().LISTEN,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 60 * time.Second, // health and logs wait on the node; the watcher gives up after 60s too
+		IdleTimeout:  120 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		panic(err)
+	}
+}
+
+func only(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Result is the answer of the control endpoints
+type Result struct {
+	OK     bool   `json:"ok"`
+	Output string `json:"output"`
+}
+
+func systemctl(args ...string) Result {
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	result := Result{OK: err == nil, Output: strings.TrimSpace(string(output))}
+	if err != nil {
+		result.Output = strings.TrimSpace(result.Output + " " + err.Error())
+	}
+	fmt.Printf("systemctl %s: %+v\n", strings.Join(args, " "), result)
+	return result
+}
+
+func respond(w http.ResponseWriter, result Result) {
+	status := http.StatusOK
+	if !result.OK {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, result)
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, checkHealth())
+}
+
+func unitHandler(verb string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, systemctl(verb, Config().SERVICE))
+	}
+}
+
+// handleFence stops the node and masks its unit persistently (in /etc), so neither a reboot nor a restart brings it back
+func handleFence(w http.ResponseWriter, r *http.Request) {
+	result := systemctl("stop", Config().SERVICE)
+	if !result.OK {
+		respond(w, result)
+		return
+	}
+	respond(w, systemctl("mask", Config().SERVICE))
+}
+
+func handleUnfence(w http.ResponseWriter, r *http.Request) {
+	respond(w, systemctl("unmask", Config().SERVICE))
+}
+
+// handleKeys reports which of the given public keys (?nmbs=0x..&rand=0x.., by key type) are in the keystore
+func handleKeys(w http.ResponseWriter, r *http.Request) {
+	keys := map[string]bool{}
+	for keyType, publicKeys := range r.URL.Query() {
+		if len(keyType) != 4 {
+			http.Error(w, "key types have 4 characters", http.StatusBadRequest)
+			return
+		}
+		for _, publicKey := range publicKeys {
+			has, err := hasKey(publicKey, keyType)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			keys[keyType+":"+publicKey] = has
+		}
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// handleLogs returns the last ?lines= lines of the node's journal
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+	lines, err := strconv.Atoi(r.URL.Query().Get("lines"))
+	if err != nil || lines <= 0 {
+		lines = 100
+	}
+	if lines > Config().MAX_LOG_LINES {
+		lines = Config().MAX_LOG_LINES
+	}
+	output, err := exec.Command("journalctl", "-u", Config().SERVICE, "-n", strconv.Itoa(lines), "--no-pager", "-o", "cat").Output()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(output)
+}
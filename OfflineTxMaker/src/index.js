@@ -22,11 +22,16 @@ const {
   telemetryWatcherDynamoAccessKeyID,
   telemetryWatcherDynamoSecret
 } = secretsData;
-const { collatorAccount, proxyAccount, proxyAccountPrivateKeyEncrypted } = accountData;
+// accounts.json lists every collator (matched to sessions by their `collator` field);
+// the old single collator layout is still accepted
+const collators = accountData.collators || [{ name: 'default', ...accountData }];
 const proxyType = "AuthorMapping";
 
 // const TX_VALIDITY_IN_DAYS = 30 * 1; // for how many days will this transaction be valid
 const NONCES_AHEAD = 30; // for how many nonces ahead of the current nonce should we generate valid transactions for
+// table of the watcher's tx store (TX_BACKEND dynamodb); when empty, txs go to the transactions blob of each session
+const TX_TABLE = ''
+const KEY_VERSION = 1 // bump when keyCaller, keyEnv or the KMS key change, so stored txs show which keys they need
 const KMS_ARN = "arn:aws:kms:eu-central-1:XXXXXXXXXXXXXX:key/YOUR-KEY-XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX"
 
 var kms = new AWS.KMS(new AWS.Config({
@@ -44,7 +49,9 @@ let documentClient = new AWS.DynamoDB.DocumentClient(new AWS.Config({
  * nodeName: string; a unique human-readable identifier of our node that also shows up on Telemetry
  * groupName: string; the network or chain, i.e. moonriver; required to be able to monitor multiple networks
  * transactions: string; a json string that stores all potential encrypted presigned raw transactions
+ * collator: string; the name of the collator in accounts.json the node authors for (optional with one collator)
  * session: string; the session ID of the node (the one we use in authorMapping.updateAssociation)
+ * vrfKey: string; the VRF key of the node, only needed by runtimes with authorMapping.setKeys
  * 
  * The program will then generate NONCES_AHEAD raw transaction strings (while incrementing the nonce)
  * for every possible pair session1 -> session2
@@ -57,36 +64,64 @@ let documentClient = new AWS.DynamoDB.DocumentClient(new AWS.Config({
   try {
     console.log('Getting sessions from db')
     const sessions = await scanSessions()
-    let nodeNamesToSessionPairs = new Map()
 
-    for (const from of sessions) {
-      nodeNamesToSessionPairs.set(from.nodeName, {})
-      for (const to of sessions) {
-        if (from.nodeName === to.nodeName) {
-          continue // no point in swithing to the same node/session
+    for (const collator of collators) {
+      const { name, collatorAccount, proxyAccount, proxyAccountPrivateKeyEncrypted } = collator
+      // a session without a collator belongs to the only collator, as in the watcher
+      const collatorSessions = sessions.filter(s => s.collator === name || (!s.collator && collators.length === 1))
+      console.log(`Collator ${name}: ${collatorSessions.length} sessions`)
+
+      // transactions are only made between the sessions of one collator, since they are signed by its proxy
+      let nodeNamesToSessionPairs = new Map()
+      for (const from of collatorSessions) {
+        nodeNamesToSessionPairs.set(from.nodeName, {})
+        for (const to of collatorSessions) {
+          if (from.nodeName === to.nodeName) {
+            continue // no point in swithing to the same node/session
+          }
+          nodeNamesToSessionPairs.set(from.nodeName, {
+            ...nodeNamesToSessionPairs.get(from.nodeName),
+            [to.nodeName]: [from.session, to.session, to.vrfKey]
+          });
+        }
+      }
+
+      // every proxy of the collator (most preferred first) gets its own set of transactions
+      // the first proxy's txs are keyed by the target node name, the others by "nodeName@proxyAccount"
+      const proxies = collator.proxies || [{ proxyAccount, proxyAccountPrivateKeyEncrypted }]
+      const fromToTxAll = new Map()
+      for (const [rank, proxy] of proxies.entries()) {
+        // we encrypt the tx with 3 keys sourced from different places/accounts
+        // none of these keys is stored in the AWS account that has the DB with the encrypted transactions
+        let masterKey = await kmsDecrypt(proxy.proxyAccountPrivateKeyEncrypted)
+        masterKey = decrypt(keyEnv, masterKey)
+        masterKey = '0x' + decrypt(keyCaller, masterKey)
+        console.log(`"${masterKey.substring(0, 5)}"`)
+
+        console.log(`Generating txs for proxy ${proxy.proxyAccount}`)
+        // console.log(nodeNamesToSessionPairs)
+        const fromToTx = await makeOfflineTx(nodeNamesToSessionPairs, masterKey, collatorAccount, proxy.proxyAccount);
+        for (const [from, txs] of fromToTx) {
+          const keyed = {}
+          for (const to in txs) {
+            keyed[rank === 0 ? to : `${to}@${proxy.proxyAccount}`] = txs[to]
+          }
+          fromToTxAll.set(from, { ...fromToTxAll.get(from), ...keyed })
         }
-        nodeNamesToSessionPairs.set(from.nodeName, {
-          ...nodeNamesToSessionPairs.get(from.nodeName),
-          [to.nodeName]: [from.session, to.session]
-        });
       }
-    }
 
-    // we encrypt the tx with 3 keys sourced from different places/accounts
-    // none of these keys is stored in the AWS account that has the DB with the encrypted transactions
-    let masterKey = await kmsDecrypt(proxyAccountPrivateKeyEncrypted)
-    masterKey = decrypt(keyEnv, masterKey)
-    masterKey = '0x' + decrypt(keyCaller, masterKey)
-    console.log(`"${masterKey.substring(0, 5)}"`)
-
-    console.log('Generating txs')
-    // console.log(nodeNamesToSessionPairs)
-    const fromToTx = await makeOfflineTx(nodeNamesToSessionPairs, masterKey);
-
-    console.log('Updating signed txs in db')
-    console.log(fromToTx)
-    for (const [fromNodeName, txs] of fromToTx) {
-      await updateSessionSignedTxs(fromNodeName, JSON.stringify(txs))
+      console.log('Updating signed txs in db')
+      console.log(fromToTxAll)
+      for (const [fromNodeName, txs] of fromToTxAll) {
+        if (TX_TABLE) {
+          // one window per (collator, from, to, proxy), each replaced atomically
+          for (const key in txs) {
+            await replaceTxWindow(name, fromNodeName, key.split('@')[0], txs[key])
+          }
+        } else {
+          await updateSessionSignedTxs(fromNodeName, JSON.stringify(txs))
+        }
+      }
     }
     console.log('Finished')
 
@@ -97,7 +132,7 @@ let documentClient = new AWS.DynamoDB.DocumentClient(new AWS.Config({
 
 
 
-async function makeOfflineTx(nodeNamesToSessionPairs, masterKey) {
+async function makeOfflineTx(nodeNamesToSessionPairs, masterKey, collatorAccount, proxyAccount) {
   const api = await providePolkadotApi();
   await api.isReady;
   await new Promise((resolve) => {
@@ -129,15 +164,24 @@ async function makeOfflineTx(nodeNamesToSessionPairs, masterKey) {
     fromToTx.set(from, {})
     // make transactions for all possible new sessions (this is the 'new' in authorMapping.updateAssociation)
     for (const to in value) {
-      const [sessionFrom, sessionTo] = value[to]
+      const [sessionFrom, sessionTo, vrfTo] = value[to]
       console.log([sessionFrom, sessionTo])
       // make a transaction for all future nonces, up to current + NONCES_AHEAD
       // this is necessary to ensure we can execute the transaction even if the nonce has changed
       const nonceTxs = [];
       for (let n = nonceProxy; n < nonceProxy + NONCES_AHEAD; n++) {
         console.log(`${from} -> ${to}, ${n}`)
-        const txUpdate = api.tx.authorMapping
-          .updateAssociation(sessionFrom, sessionTo)
+        // runtimes with nimbus + VRF keys replaced updateAssociation(old, new) with setKeys(nimbus ++ vrf)
+        // keep this choice in line with SET_KEYS_SPEC_VERSION in the watcher config
+        let txUpdate
+        if (api.tx.authorMapping.setKeys) {
+          if (!vrfTo) {
+            throw new Error(`${to} has no vrfKey, required by authorMapping.setKeys`)
+          }
+          txUpdate = api.tx.authorMapping.setKeys(sessionTo + vrfTo.replace(/^0x/, ''))
+        } else {
+          txUpdate = api.tx.authorMapping.updateAssociation(sessionFrom, sessionTo)
+        }
 
         const unsignedProxy = getProxyTx(
           {
@@ -183,7 +227,16 @@ async function makeOfflineTx(nodeNamesToSessionPairs, masterKey) {
       // console.log(`tx: ${tx}`)
       fromToTx.set(from, {
         ...fromToTx.get(from),
-        [to]: { txs: nonceTxs, nonce: nonceProxy }
+        // the watcher compares the versions against the chain to detect txs invalidated by a runtime upgrade
+        [to]: {
+          txs: nonceTxs,
+          nonce: nonceProxy,
+          proxy: proxyAccount,
+          specVersion: specVersion.toNumber(),
+          transactionVersion: transactionVersion.toNumber(),
+          createdAt: Math.floor(Date.now() / 1000),
+          keyVersion: KEY_VERSION
+        }
       })
     }
   }
@@ -286,6 +339,54 @@ async function updateSessionSignedTxs(nodeName, txs) {
   await documentClient.update(params).promise();
 }
 
+/**
+ * Same layout as the watcher's dynamodb tx store: a header item per pair (nonce -1) points to the
+ * generation holding the txs (one item per nonce). New txs are written under a new generation and the
+ * header is switched with a conditional put, so the watcher never reads half a window
+ */
+async function replaceTxWindow(collator, from, to, window) {
+  const pair = [collator, from, to, window.proxy.toLowerCase()].join('/')
+  const { Item: previous } = await documentClient.get({
+    TableName: TX_TABLE,
+    Key: { pair, nonce: -1 },
+    ConsistentRead: true
+  }).promise()
+  const generation = crypto.randomBytes(8).toString('hex')
+  const { txs, ...meta } = window
+  await batchWriteTxs(txs.map((tx, i) => ({ PutRequest: { Item: { pair: `${pair}#${generation}`, nonce: window.nonce + i, tx } } })))
+
+  const params = {
+    TableName: TX_TABLE,
+    Item: { pair, nonce: -1, generation, window: { ...meta, collator, from, to, count: txs.length } },
+    ConditionExpression: 'attribute_not_exists(#p)',
+    ExpressionAttributeNames: { '#p': 'pair' }
+  }
+  if (previous) {
+    params.ConditionExpression = '#g = :g'
+    params.ExpressionAttributeNames = { '#g': 'generation' }
+    params.ExpressionAttributeValues = { ':g': previous.generation }
+  }
+  await documentClient.put(params).promise()
+
+  if (previous) {
+    const old = []
+    for (let n = previous.window.nonce; n < previous.window.nonce + previous.window.count; n++) {
+      old.push({ DeleteRequest: { Key: { pair: `${pair}#${previous.generation}`, nonce: n } } })
+    }
+    await batchWriteTxs(old)
+  }
+}
+
+async function batchWriteTxs(requests) {
+  for (let i = 0; i < requests.length; i += 25) {
+    let batch = { [TX_TABLE]: requests.slice(i, i + 25) }
+    while (Object.keys(batch).length > 0) {
+      const { UnprocessedItems } = await documentClient.batchWrite({ RequestItems: batch }).promise()
+      batch = UnprocessedItems || {}
+    }
+  }
+}
+
 async function kmsDecrypt(cipher) {
   var params = {
     CiphertextBlob: Buffer.from(cipher, 'base64'), // The encrypted data (ciphertext).
@@ -304,6 +405,7 @@ async function kmsEncrypt(text) {
   return CiphertextBlob.toString('base64');
 }
 
+// TelemetryWatch/src/txcrypt implements encrypt, decrypt and the KMS layer in Go; run src/vectors.js after changing them
 function encrypt(secret, text) {
   const hash = crypto.createHash('sha256').update(String(secret)).digest('base64').substr(0, 32);
   const iv = Buffer.alloc(16, 0); // Initialization vector.
@@ -0,0 +1,82 @@
+/**
+ * Writes the golden vectors of the Go implementation of the tx encryption (TelemetryWatch/src/txcrypt),
+ * which its tests load from txcrypt/testdata/vectors.json.
+ * The encrypt, decrypt, kmsEncrypt and kmsDecrypt functions are taken from index.js as they are, with
+ * KMS replaced by the local stand-in of txcrypt: AES-256-GCM under sha256(secret), 12 byte nonce first.
+ * Run with `node src/vectors.js` after changing them, and commit the fixture
+ */
+const fs = require('fs');
+const path = require('path');
+const crypto = require("crypto");
+
+const KMS_ARN = 'local'
+const FIXTURE = path.join(__dirname, '../../TelemetryWatch/src/txcrypt/testdata/vectors.json')
+const LOCAL_KMS_SECRET = 'local-kms-secret'
+
+function localKMSKey() {
+  return crypto.createHash('sha256').update(LOCAL_KMS_SECRET).digest()
+}
+
+var kms = {
+  encrypt: (params) => ({
+    promise: async () => {
+      const nonce = crypto.randomBytes(12)
+      const cipher = crypto.createCipheriv('aes-256-gcm', localKMSKey(), nonce)
+      const sealed = Buffer.concat([cipher.update(params.Plaintext), cipher.final()])
+      return { CiphertextBlob: Buffer.concat([nonce, sealed, cipher.getAuthTag()]), KeyId: KMS_ARN }
+    }
+  }),
+  decrypt: (params) => ({
+    promise: async () => {
+      const blob = params.CiphertextBlob
+      const decipher = crypto.createDecipheriv('aes-256-gcm', localKMSKey(), blob.slice(0, 12))
+      decipher.setAuthTag(blob.slice(blob.length - 16))
+      const plain = Buffer.concat([decipher.update(blob.slice(12, blob.length - 16)), decipher.final()])
+      return { Plaintext: plain, KeyId: KMS_ARN }
+    }
+  })
+}
+
+// the functions of index.js, unchanged
+const source = fs.readFileSync(path.join(__dirname, 'index.js'), 'utf8')
+function extract(name) {
+  const start = source.search(new RegExp(`(async )?function ${name}\\(`))
+  const end = source.indexOf('\n}\n', start)
+  if (start < 0 || end < 0) {
+    throw new Error(`${name} not found in index.js`)
+  }
+  return source.substring(start, end + 2)
+}
+const js = {}
+eval([extract('encrypt'), extract('decrypt'), extract('kmsEncrypt'), extract('kmsDecrypt')].join('\n')
+  + '\njs.encrypt = encrypt; js.decrypt = decrypt; js.kmsEncrypt = kmsEncrypt; js.kmsDecrypt = kmsDecrypt')
+
+const cases = [
+  { keyCaller: 'caller-secret', keyEnv: 'env-secret', plain: '0x' + 'a1'.repeat(60) }, // a signed tx
+  { keyCaller: 'caller-secret', keyEnv: 'env-secret', plain: '5fb92d6e98884f76de468fa3f6278f8807c48bebc13595d45af5bdc4da702133' }, // a private key
+  { keyCaller: 'k', keyEnv: 'another env key, longer than 32 characters', plain: '' },
+  { keyCaller: 'ünïcode', keyEnv: '1234', plain: 'exactly sixteen!' },
+]
+
+async function main() {
+  const vectors = []
+  for (const c of cases) {
+    let layered = js.encrypt(c.keyCaller, c.plain)
+    layered = js.encrypt(c.keyEnv, layered)
+    const wrapped = await js.kmsEncrypt(layered)
+    let plain = await js.kmsDecrypt(wrapped)
+    plain = js.decrypt(c.keyEnv, plain)
+    plain = js.decrypt(c.keyCaller, plain)
+    if (plain !== c.plain) {
+      throw new Error(`round trip failed for ${c.plain}`)
+    }
+    vectors.push({ ...c, layered, wrapped })
+  }
+  fs.writeFileSync(FIXTURE, JSON.stringify({ localKMSSecret: LOCAL_KMS_SECRET, vectors }, null, 2) + '\n')
+  console.log(`${vectors.length} vectors written to ${FIXTURE}`)
+}
+
+main().catch((err) => {
+  console.error(err)
+  process.exit(1)
+})
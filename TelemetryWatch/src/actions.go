@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"movrfailover/services"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Action is a pluggable operation on a node, such as a shell hook or an HTTP call
+type Action interface {
+	Run(session *services.Session) error
+}
+
+var actionTypes = map[string]func(config services.ActionConfig) (Action, error){
+	"shell":   newShellAction,
+	"ssh":     newSSHAction,
+	"agent":   newAgentAction,
+	"http":    newHTTPAction,
+	"webhook": newWebhookAction,
+}
+
+func newAction(config services.ActionConfig) (Action, error) {
+	constructor, ok := actionTypes[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("Unknown action type %s", config.Type)
+	}
+	return constructor(config)
+}
+
+func actionTimeout(config services.ActionConfig) time.Duration {
+	if config.TimeoutInSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(config.TimeoutInSeconds) * time.Second
+}
+
+// expandNode replaces {nodeName} and {groupName} in action settings
+func expandNode(value string, session *services.Session) string {
+	return strings.NewReplacer("{nodeName}", session.NodeName, "{groupName}", session.GroupName).Replace(value)
+}
+
+// expandShell replaces {nodeName} and {groupName} in a shell command with their single-quoted values,
+// so a name is always one word and never shell syntax; the placeholders must not be quoted again
+func expandShell(command string, session *services.Session) string {
+	return strings.NewReplacer("{nodeName}", shellQuote(session.NodeName), "{groupName}", shellQuote(session.GroupName)).Replace(command)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// shellAction runs a command with sh -c; NODE_NAME and GROUP_NAME are set in its environment
+type shellAction struct {
+	command string
+	timeout time.Duration
+}
+
+func newShellAction(config services.ActionConfig) (Action, error) {
+	if config.Command == "" {
+		return nil, fmt.Errorf("shell action without command")
+	}
+	return &shellAction{command: config.Command, timeout: actionTimeout(config)}, nil
+}
+
+func (a *shellAction) Run(session *services.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", expandShell(a.command, session))
+	cmd.Env = append(os.Environ(), "NODE_NAME="+session.NodeName, "GROUP_NAME="+session.GroupName)
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s", output)
+	if err != nil {
+		return fmt.Errorf("%s on %s: %v", a.command, session.NodeName, err)
+	}
+	return nil
+}
+
+// sshAction runs a command on Host (which may use {nodeName}) with the ssh client, without prompting.
+// The remote shell parses the command, so the names are quoted into it as for shellAction
+type sshAction struct {
+	config  services.ActionConfig
+	timeout time.Duration
+}
+
+func newSSHAction(config services.ActionConfig) (Action, error) {
+	if config.Host == "" || config.Command == "" {
+		return nil, fmt.Errorf("ssh action needs host and command")
+	}
+	return &sshAction{config: config, timeout: actionTimeout(config)}, nil
+}
+
+func (a *sshAction) Run(session *services.Session) error {
+	host := expandNode(a.config.Host, session)
+	if a.config.User != "" {
+		host = a.config.User + "@" + host
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10", "--", host, expandShell(a.config.Command, session))
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s", output)
+	if err != nil {
+		return fmt.Errorf("ssh %s %s: %v", host, a.config.Command, err)
+	}
+	return nil
+}
+
+// agentAction asks the node agent of the session to restart, stop or fence the node
+type agentAction struct {
+	verb string
+}
+
+func newAgentAction(config services.ActionConfig) (Action, error) {
+	switch config.Command {
+	case "restart", "stop", "fence":
+		return &agentAction{verb: config.Command}, nil
+	}
+	return nil, fmt.Errorf("agent action must be restart, stop or fence, not %s", config.Command)
+}
+
+func (a *agentAction) Run(session *services.Session) error {
+	agent, err := services.Agent(session)
+	if err != nil {
+		return err
+	}
+	return agent.Control(a.verb)
+}
+
+// httpAction calls an HTTP endpoint and expects a 2xx answer
+type httpAction struct {
+	config  services.ActionConfig
+	timeout time.Duration
+}
+
+func newHTTPAction(config services.ActionConfig) (Action, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("http action without url")
+	}
+	if config.Method == "" {
+		config.Method = "POST"
+	}
+	return &httpAction{config: config, timeout: actionTimeout(config)}, nil
+}
+
+func (a *httpAction) Run(session *services.Session) error {
+	url := expandNode(a.config.URL, session)
+	req, err := http.NewRequest(a.config.Method, url, bytes.NewBufferString(expandNode(a.config.Body, session)))
+	if err != nil {
+		return err
+	}
+	for header, value := range a.config.Headers {
+		req.Header.Set(header, value)
+	}
+	client := &http.Client{Timeout: a.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s on %s: %s %s", a.config.Method, url, session.NodeName, resp.Status, body)
+	}
+	return nil
+}
+
+// webhookAction posts the node and the event (Body, "fence" if empty) as JSON, e.g. to a chat or incident tool
+type webhookAction struct {
+	config  services.ActionConfig
+	timeout time.Duration
+}
+
+func newWebhookAction(config services.ActionConfig) (Action, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("webhook action without url")
+	}
+	if config.Body == "" {
+		config.Body = "fence"
+	}
+	return &webhookAction{config: config, timeout: actionTimeout(config)}, nil
+}
+
+func (a *webhookAction) Run(session *services.Session) error {
+	payload, err := json.Marshal(map[string]string{
+		"event":     a.config.Body,
+		"nodeName":  session.NodeName,
+		"groupName": session.GroupName,
+		"collator":  session.Collator,
+	})
+	if err != nil {
+		return err
+	}
+	config := a.config
+	config.Method = "POST"
+	config.Body = string(payload)
+	config.Headers = map[string]string{"Content-Type": "application/json"}
+	for header, value := range a.config.Headers {
+		config.Headers[header] = value
+	}
+	return (&httpAction{config: config, timeout: a.timeout}).Run(session)
+}
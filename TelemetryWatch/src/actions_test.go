@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"movrfailover/services"
+)
+
+func TestShellActionQuotesNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	injected := filepath.Join(dir, "injected")
+
+	names := []string{
+		"node-a",
+		"node; touch " + injected,
+		"$(touch " + injected + ")",
+		"`touch " + injected + "`",
+		"it's \"quoted\"",
+	}
+	for _, name := range names {
+		session := &services.Session{NodeName: name, GroupName: "moonriver"}
+		action := &shellAction{command: "printf %s {nodeName} > " + shellQuote(out), timeout: 10 * time.Second}
+		if err := action.Run(session); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		written, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(written) != name {
+			t.Errorf("command received %q instead of %q", written, name)
+		}
+		if _, err := os.Stat(injected); err == nil {
+			t.Fatalf("node name %q ran as shell code", name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"movrfailover/services"
+)
+
+// agentCommand asks the agent of a node for its health, whether it holds the session keys, or its log tail:
+// `movrfailover agent health|keys|logs --node <name> [flags]`
+func agentCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: movrfailover agent health|keys|logs --node <name> [flags]")
+	}
+	request := args[0]
+	switch request {
+	case "health", "keys", "logs":
+	default:
+		return fmt.Errorf("unknown agent command %s", request)
+	}
+	flags := flag.NewFlagSet("agent "+request, flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	node := flags.String("node", "", "node name")
+	lines := flags.Int("lines", 100, "number of log lines, for logs")
+	flags.Parse(args[1:])
+	services.ENVIR = *envir
+	if *node == "" {
+		return errors.New("--node is required")
+	}
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.NodeName != *node {
+			continue
+		}
+		agent, err := services.Agent(session)
+		if err != nil {
+			return err
+		}
+		switch request {
+		case "health":
+			health, err := agent.Health()
+			if err != nil {
+				return err
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(health)
+		case "keys":
+			has, err := agent.HasKeys(session.Session, session.VrfKey)
+			if err != nil {
+				return err
+			}
+			if !has {
+				return fmt.Errorf("%s does not hold the keys of its session", *node)
+			}
+			fmt.Printf("%s holds the keys of its session\n", *node)
+			return nil
+		default:
+			logs, err := agent.Logs(*lines)
+			if err != nil {
+				return err
+			}
+			fmt.Print(logs)
+			return nil
+		}
+	}
+	return fmt.Errorf("No session %s", *node)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"movrfailover/services"
+)
+
+/**
+Subcommands run instead of the watcher: `movrfailover <command> [flags]`.
+Without a known command the first argument is the environment, as before
+**/
+var commands = map[string]func(args []string) error{
+	"agent":    agentCommand,
+	"drain":    drainCommand,
+	"fence":    fenceCommand,
+	"journal":  journalCommand,
+	"sessions": sessionsCommand,
+	"switch":   switchCommand,
+	"txs":      txsCommand,
+	"upgrade":  upgradeCommand,
+}
+
+func runCommand() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		return false
+	}
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// journalCommand prints the journaled reassociation attempts of a collator (or all) in a time range as JSON lines
+func journalCommand(args []string) error {
+	flags := flag.NewFlagSet("journal", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	collator := flags.String("collator", "", "collator name, empty for all")
+	since := flags.String("since", "", "RFC3339 start time, empty for the beginning")
+	until := flags.String("until", "", "RFC3339 end time, empty for now")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	from, err := parseTime(*since)
+	if err != nil {
+		return err
+	}
+	to, err := parseTime(*until)
+	if err != nil {
+		return err
+	}
+	entries, err := services.Journal().Query(*collator, from, to)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTime parses an RFC3339 time into unix seconds, 0 if empty
+func parseTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time %s: %v", value, err)
+	}
+	return t.Unix(), nil
+}
@@ -9,13 +9,62 @@ import (
 )
 
 var alerts = make(chan services.PinpointMessage, 100)
-var notifiedAt = map[string]int{} // time we sent an alert for groupname
+var notifiedAt = map[string]int{} // time we sent an alert for a job (or a drain)
 var naMX sync.RWMutex             // mx for notifiedAt
 
-func delegate(gpName string, ses []*services.Session) {
-	time.Sleep(30 * time.Second) // wait to get first blocks
+var collatorLocks = map[string]*sync.Mutex{} // collator -> serializes the failovers that spend its proxy nonce
+var lastNonce = map[string]int{}             // collator -> last proxy nonce used for a reassociation
+var clMX sync.Mutex                          // mx for collatorLocks and lastNonce
+
+// failoverJob is the failover loop of one collator in one group (network)
+// Jobs are isolated: each watches, alerts and fails over only its own sessions
+type failoverJob struct {
+	Collator  *services.Collator
+	GroupName string
+	Sessions  []*services.Session // replaced as a whole by reloads; read it with sessions()
+	stop      chan struct{}       // closed when the job is removed by a reload
+	mx        sync.RWMutex        // mx for Sessions
+}
+
+func (job *failoverJob) Key() string {
+	return job.Collator.Name + "/" + job.GroupName
+}
+
+// sessions returns a copy of the job's sessions, safe to sort and iterate while reloads happen
+func (job *failoverJob) sessions() []*services.Session {
+	job.mx.RLock()
+	defer job.mx.RUnlock()
+	ses := make([]*services.Session, len(job.Sessions))
+	copy(ses, job.Sessions)
+	return ses
+}
+
+func (job *failoverJob) setSessions(sessions []*services.Session) {
+	job.mx.Lock()
+	job.Sessions = sessions
+	job.mx.Unlock()
+}
+
+// sleep waits for d, returning false if the job was stopped in the meantime
+func (job *failoverJob) sleep(d time.Duration) bool {
+	select {
+	case <-job.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func delegate(job *failoverJob) {
+	if !waitFirstBlocks(job) {
+		return
+	}
 	for {
-		time.Sleep(time.Duration(services.Config().BLOCK_CHECK_PERIOD_IN_SECONDS) * time.Second)
+		if !job.sleep(time.Duration(services.Config().BLOCK_CHECK_PERIOD_IN_SECONDS) * time.Second) {
+			fmt.Printf("Stopped delegator of %s\n", job.Key())
+			return
+		}
+		ses := job.sessions()
 
 		sort.Slice(ses, func(i, j int) bool {
 			return ses[i].Priority < ses[j].Priority
@@ -27,6 +76,10 @@ func delegate(gpName string, ses []*services.Session) {
 		reassociateDo := false
 		notifyDo := false
 		whosActive := map[string]*WhosActive{}
+		lagOf := map[string]string{} // node -> why it is considered lagging
+		verdicts := []*services.Verdict{}
+		dissent := map[string][]string{}
+		var health []services.NodeHealth
 		var err error
 
 		niMX.RLock()
@@ -36,13 +89,28 @@ func delegate(gpName string, ses []*services.Session) {
 			maxFinalized = services.Max(nodeFinalized[session.NodeName], maxFinalized)
 		}
 		for _, session := range ses {
+			if isDraining(session) {
+				continue // handed over by drainNodes; its lag is expected
+			}
 			importedLag := maxImported-nodeImported[session.NodeName] > services.Config().IMPORTED_REASSOCIATE_THRESHOLD
 			finalizedLag := maxFinalized-nodeFinalized[session.NodeName] > services.Config().FINALIZED_REASSOCIATE_THRESHOLD
 
+			if importedLag || finalizedLag {
+				setNodeState(session.NodeName, NodeLagging, "behind the best node of the group")
+			} else {
+				setNodeState(session.NodeName, NodeHealthy, "keeping up with the group")
+				session.NotSynced = false // caught up (e.g. remediated), a candidate again
+				verdicts = append(verdicts, newVerdict(session, true, "keeping up with the group"))
+			}
+
 			if importedLag || finalizedLag {
 				// if any node is lagging, we notify
 				notifyDo = true
 				session.NotSynced = true
+				lagOf[session.NodeName] = fmt.Sprintf("%s lagging: imported %d behind (threshold %d), finalized %d behind (threshold %d)",
+					session.NodeName, maxImported-nodeImported[session.NodeName], services.Config().IMPORTED_REASSOCIATE_THRESHOLD,
+					maxFinalized-nodeFinalized[session.NodeName], services.Config().FINALIZED_REASSOCIATE_THRESHOLD)
+				verdicts = append(verdicts, newVerdict(session, false, lagOf[session.NodeName]))
 				if len(whosActive) == 0 { // request active sessions only if there is an issue
 					fmt.Println("Getting which sessions are active/associated")
 					whosActive, err = getActiveSessions(ses)
@@ -52,55 +120,124 @@ func delegate(gpName string, ses []*services.Session) {
 				}
 				if act, ok := whosActive[session.Session]; ok && act.Active {
 					// if this is the currently associated node, we must reassociate
+					// (again, if it was switched back to after an earlier failover)
 					onAlert = append(onAlert, session)
 					reassociateDo = true
+				} else if err == nil && isLeader() {
+					remediateBackup(job, session, lagOf[session.NodeName])
 				}
 			}
 		}
+		if reassociateDo {
+			health = healthSnapshot(ses, whosActive)
+		}
 		nfMX.RUnlock()
 		niMX.RUnlock()
 
+		publishVerdicts(verdicts)
+		if !isLeader() {
+			continue // followers keep their state warm; the leader acts
+		}
+		if reassociateDo {
+			// the quorum comes first: one watcher with a bad view must not restart a healthy active node either
+			onAlert, dissent = confirmQuorum(job, onAlert, lagOf)
+			onAlert = remediateActive(job, onAlert, lagOf)
+			reassociateDo = len(onAlert) > 0
+		}
 		if reassociateDo {
-			fmt.Println("Reassociation is required (associated node found to be lagging)")
+			detected := time.Now()
+			fmt.Printf("Reassociation is required for %s (associated node found to be lagging)\n", job.Key())
+			unlock := lockCollator(job.Collator.Name)
 			for _, sessAlert := range onAlert { // these are active nodes that are not syncing
 				// we need the nonce of the proxy account, since we are using a proxy
 				// we can skip this step if we are not using proxy
-				fmt.Println("Getting nonce of proxy acount")
-				nonces, err := getAccountNonces([]string{sessAlert.Proxy})
+				fmt.Println("Getting nonces of proxy acounts")
+				nonces, err := getAccountNonces(job.Collator.ProxiesFor(sessAlert))
 				if err != nil {
 					fmt.Printf("%v\n", err)
 					continue
 				}
-				if _, ok := nonces[sessAlert.Proxy]; !ok {
+				if len(nonces) == 0 {
 					fmt.Println("Failed to find nonce!")
 					continue
 				}
-				whosActive[sessAlert.Session].Nonce = nonces[sessAlert.Proxy]
 
 				// find next available backup replacement
-				err = failover(sessAlert, ses, &whosActive)
+				inc := &incident{Detected: detected, Trigger: lagOf[sessAlert.NodeName], Health: health, Dissent: dissent[sessAlert.NodeName]}
+				err = failover(job.Collator, sessAlert, ses, &whosActive, nonces, inc)
 				if err != nil {
 					fmt.Printf("%v\n", err)
 					continue
 				}
 			}
+			unlock()
 		}
 
+		drainNodes(job)
+
 		naMX.RLock()
-		notRecentlyNotified := notifiedAt[gpName] == 0 || notifiedAt[gpName] < int(time.Now().Unix())-services.Config().ALERT_CHILL_PERIOD_IN_MINUTES*60
+		notRecentlyNotified := notifiedAt[job.Key()] == 0 || notifiedAt[job.Key()] < int(time.Now().Unix())-services.Config().ALERT_CHILL_PERIOD_IN_MINUTES*60
 		naMX.RUnlock()
 		if notifyDo && notRecentlyNotified {
 			// NOTIFY
 			fmt.Println("Notifying")
-			notifyMe(fmt.Sprintf(`Check %s`, gpName))
+			notifyCollator(job.Collator, fmt.Sprintf(`Check %s`, job.GroupName))
 			naMX.Lock()
-			notifiedAt[gpName] = int(time.Now().Unix())
+			notifiedAt[job.Key()] = int(time.Now().Unix())
 			naMX.Unlock()
 		}
 	}
 }
 
+// healthSnapshot records the state of every session for the journal; the caller holds niMX and nfMX
+func healthSnapshot(sessions []*services.Session, whosActive map[string]*WhosActive) []services.NodeHealth {
+	health := []services.NodeHealth{}
+	for _, session := range sessions {
+		health = append(health, services.NodeHealth{
+			NodeName:  session.NodeName,
+			Priority:  session.Priority,
+			Imported:  nodeImported[session.NodeName],
+			Finalized: nodeFinalized[session.NodeName],
+			Active:    whosActive[session.Session] != nil && whosActive[session.Session].Active,
+			NotSynced: session.NotSynced,
+		})
+	}
+	return health
+}
+
+// lockCollator serializes failovers of one collator across its groups, so they never sign with the same proxy nonce
+func lockCollator(name string) func() {
+	clMX.Lock()
+	if _, ok := collatorLocks[name]; !ok {
+		collatorLocks[name] = &sync.Mutex{}
+	}
+	mx := collatorLocks[name]
+	clMX.Unlock()
+	mx.Lock()
+	return mx.Unlock
+}
+
+func recordNonce(collator string, nonce int) {
+	clMX.Lock()
+	lastNonce[collator] = nonce
+	clMX.Unlock()
+}
+
+// notifyCollator sends an alert labelled with the collator it is about
+func notifyCollator(collator *services.Collator, message string) {
+	notifyMe(fmt.Sprintf("[%s] %s", collator.Name, message))
+}
+
+// notifyMe alerts unless another watcher leads; the leader sends the alerts for all
 func notifyMe(message string) {
+	if !isLeader() {
+		fmt.Printf("Not the leader, alert not sent: %s\n", message)
+		return
+	}
+	queueAlert(message)
+}
+
+func queueAlert(message string) {
 	pm := services.PinpointMessage{
 		Subject:   "DIVNET ALERT",
 		EmailHTML: "<p>" + message + "</p>",
@@ -126,12 +263,34 @@ func processAlerts() {
 	}
 }
 
+// sendQueuedAlerts sends the alerts still queued, for commands that exit instead of running processAlerts
+func sendQueuedAlerts() {
+	for len(alerts) > 0 {
+		alert := <-alerts
+		if err := services.SendPinpoint(&alert); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+	}
+}
+
 func reportStatus() {
 	for {
+		jobs := currentJobs()
 		nodeCountMX.RLock()
 		fmt.Printf("Nodes: %v\n", nodeCount)
 		nodeCountMX.RUnlock()
 
+		clMX.Lock()
+		for _, job := range jobs {
+			nonce, ok := lastNonce[job.Collator.Name]
+			nonceStr := "none"
+			if ok {
+				nonceStr = fmt.Sprintf("%d", nonce)
+			}
+			fmt.Printf("[%s] %s: %d sessions, last reassociation nonce %s\n", job.Collator.Name, job.GroupName, len(job.sessions()), nonceStr)
+		}
+		clMX.Unlock()
+
 		time.Sleep(60 * time.Second)
 	}
 }
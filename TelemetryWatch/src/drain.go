@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"movrfailover/services"
+	"sort"
+	"sync"
+	"time"
+)
+
+var drains = map[string]*services.Drain{} // nodeName -> maintenance window, refreshed from the db
+var handedOver = map[string]bool{}        // nodeName -> association handed over (or was not held) for its window
+var drMX sync.RWMutex                     // mx for drains and handedOver
+
+/**
+A node can be drained for planned maintenance (`movrfailover drain`). Ahead of its window the delegator
+hands the association over to a healthy backup; while the window is open the node is no candidate and
+its lag raises no alerts. Once the window closes the node may be returned to the association
+**/
+func seedDrains(sessions []*services.Session) {
+	drMX.Lock()
+	defer drMX.Unlock()
+	for _, session := range sessions {
+		if session.Drain != nil {
+			drains[session.NodeName] = session.Drain
+		}
+	}
+}
+
+// refreshMaintenance follows the maintenance windows and fences set by the drain and fence commands while we run
+func refreshMaintenance(sessions []*services.Session) {
+	fresh := map[string]*services.Drain{}
+	for _, session := range sessions {
+		if session.Drain != nil {
+			fresh[session.NodeName] = session.Drain
+		}
+		refreshFence(session)
+	}
+	drMX.Lock()
+	for nodeName, drain := range fresh {
+		if previous, ok := drains[nodeName]; !ok || *previous != *drain {
+			fmt.Printf("Drain of %s: %s\n", nodeName, describeDrain(drain))
+		}
+	}
+	for nodeName := range drains {
+		if _, ok := fresh[nodeName]; !ok {
+			fmt.Printf("Drain of %s cleared\n", nodeName)
+			delete(handedOver, nodeName)
+		}
+	}
+	drains = fresh
+	drMX.Unlock()
+}
+
+func drainOf(nodeName string) *services.Drain {
+	drMX.RLock()
+	defer drMX.RUnlock()
+	return drains[nodeName]
+}
+
+// isDraining reports whether a node is in (or about to enter) its maintenance window
+func isDraining(session *services.Session) bool {
+	return drainOf(session.NodeName).Active(time.Now().Unix(), int64(services.Config().DRAIN_HANDOVER_LEAD_IN_SECONDS))
+}
+
+func describeDrain(drain *services.Drain) string {
+	until := "until cleared"
+	if drain.Until > 0 {
+		until = "until " + time.Unix(drain.Until, 0).UTC().Format(time.RFC3339)
+	}
+	description := fmt.Sprintf("from %s %s", time.Unix(drain.From, 0).UTC().Format(time.RFC3339), until)
+	if drain.Return {
+		description += ", then return"
+	}
+	return description
+}
+
+// drainNodes hands the association over ahead of maintenance windows, and ends the windows that are over
+func drainNodes(job *failoverJob) {
+	now := time.Now().Unix()
+	lead := int64(services.Config().DRAIN_HANDOVER_LEAD_IN_SECONDS)
+	starting := []*services.Session{}
+	over := []*services.Session{}
+	drMX.RLock()
+	for _, session := range job.sessions() {
+		drain := drains[session.NodeName]
+		if drain.Active(now, lead) && !handedOver[session.NodeName] {
+			starting = append(starting, session)
+		} else if drain.Over(now) {
+			over = append(over, session)
+		}
+	}
+	drMX.RUnlock()
+	if len(starting) == 0 && len(over) == 0 {
+		return
+	}
+
+	whosActive, err := getActiveSessions(job.sessions())
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	unlock := lockCollator(job.Collator.Name)
+	defer unlock()
+	for _, session := range starting {
+		if act, ok := whosActive[session.Session]; !ok || !act.Active {
+			markHandedOver(session.NodeName)
+			continue
+		}
+		if err := handOver(job, session, whosActive); err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		markHandedOver(session.NodeName)
+	}
+	for _, session := range over {
+		endDrain(job, session, whosActive)
+	}
+}
+
+func markHandedOver(nodeName string) {
+	drMX.Lock()
+	handedOver[nodeName] = true
+	drMX.Unlock()
+}
+
+// handOver moves the association of a node entering maintenance to the synced backup with the highest priority
+func handOver(job *failoverJob, sessAlert *services.Session, whosActive map[string]*WhosActive) error {
+	account, err := associatedAccount(job.Collator, sessAlert, whosActive)
+	if err != nil {
+		return err
+	}
+	nonces, err := getAccountNonces(job.Collator.ProxiesFor(sessAlert))
+	if err != nil {
+		return err
+	}
+	candidates := make([]*services.Session, len(job.sessions()))
+	copy(candidates, job.sessions())
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	}) // higher priority gets activated first
+	inc := &incident{
+		Detected: time.Now(),
+		Trigger:  fmt.Sprintf("%s drained %s", sessAlert.NodeName, describeDrain(drainOf(sessAlert.NodeName))),
+		Health:   lockedHealthSnapshot(job.sessions(), whosActive),
+	}
+	for _, sessCandidate := range candidates {
+		if sessCandidate == sessAlert || isDraining(sessCandidate) || isFenced(sessCandidate) || laggingNow(sessCandidate, job.sessions()) {
+			continue
+		}
+		if act, ok := whosActive[sessCandidate.Session]; ok && act.Active {
+			continue
+		}
+		reason := fmt.Sprintf("synced backup with the highest priority (%d) for the drain of %s", sessCandidate.Priority, sessAlert.NodeName)
+		err := reassociate(job.Collator, sessAlert, sessCandidate, job.sessions(), nonces, account, inc, reason, nil)
+		if err == errReassociationInFlight {
+			return err
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		return nil
+	}
+	message := fmt.Sprintf(`Could not hand over the association of %s before its maintenance window`, sessAlert.NodeName)
+	key := "drain/" + sessAlert.NodeName
+	naMX.Lock()
+	if notifiedAt[key] < int(time.Now().Unix())-services.Config().ALERT_CHILL_PERIOD_IN_MINUTES*60 {
+		notifyCollator(job.Collator, message)
+		notifiedAt[key] = int(time.Now().Unix())
+	}
+	naMX.Unlock()
+	return errors.New(message)
+}
+
+// endDrain closes a finished window, returning the association to the node first if requested and it is healthy
+func endDrain(job *failoverJob, session *services.Session, whosActive map[string]*WhosActive) {
+	drain := drainOf(session.NodeName)
+	message := fmt.Sprintf(`Maintenance window of %s is over`, session.NodeName)
+	if act, ok := whosActive[session.Session]; drain.Return && (!ok || !act.Active) {
+		if laggingNow(session, job.sessions()) {
+			fmt.Printf("%s is not synced yet, waiting to return the association\n", session.NodeName)
+			return
+		}
+		if err := returnAssociation(job, session, whosActive); err != nil {
+			message += fmt.Sprintf(`; could not return the association: %v`, err)
+		} else {
+			message += `; association returned`
+		}
+	}
+	if err := services.UpdateSessionDrain(session.NodeName, nil); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	drMX.Lock()
+	delete(drains, session.NodeName)
+	delete(handedOver, session.NodeName)
+	drMX.Unlock()
+	session.NotSynced = false
+	fmt.Printf("%s\n", message)
+	notifyCollator(job.Collator, message)
+}
+
+func returnAssociation(job *failoverJob, sessCandidate *services.Session, whosActive map[string]*WhosActive) error {
+	sessAlert := activeSession(job, whosActive)
+	if sessAlert == nil {
+		return fmt.Errorf("No session of %s is associated", job.Collator.Name)
+	}
+	inc := &incident{
+		Detected: time.Now(),
+		Trigger:  fmt.Sprintf("maintenance window of %s is over", sessCandidate.NodeName),
+		Health:   lockedHealthSnapshot(job.sessions(), whosActive),
+	}
+	reason := fmt.Sprintf("return to %s after its maintenance window", sessCandidate.NodeName)
+	return switchOver(job, sessAlert, sessCandidate, whosActive, inc, reason, nil)
+}
+
+// laggingNow applies the reassociation thresholds to the latest telemetry of a session
+func laggingNow(session *services.Session, sessions []*services.Session) bool {
+	niMX.RLock()
+	nfMX.RLock()
+	defer nfMX.RUnlock()
+	defer niMX.RUnlock()
+	maxImported := 0
+	maxFinalized := 0
+	for _, s := range sessions {
+		maxImported = services.Max(nodeImported[s.NodeName], maxImported)
+		maxFinalized = services.Max(nodeFinalized[s.NodeName], maxFinalized)
+	}
+	return nodeImported[session.NodeName] == 0 ||
+		maxImported-nodeImported[session.NodeName] > services.Config().IMPORTED_REASSOCIATE_THRESHOLD ||
+		maxFinalized-nodeFinalized[session.NodeName] > services.Config().FINALIZED_REASSOCIATE_THRESHOLD
+}
+
+func lockedHealthSnapshot(sessions []*services.Session, whosActive map[string]*WhosActive) []services.NodeHealth {
+	niMX.RLock()
+	nfMX.RLock()
+	defer nfMX.RUnlock()
+	defer niMX.RUnlock()
+	return healthSnapshot(sessions, whosActive)
+}
+
+// drainCommand sets or clears the maintenance window of a node
+func drainCommand(args []string) error {
+	flags := flag.NewFlagSet("drain", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	node := flags.String("node", "", "node name to drain")
+	from := flags.String("from", "", "RFC3339 start of the window, empty for now")
+	until := flags.String("until", "", "RFC3339 end of the window, empty until cleared")
+	back := flags.Bool("return", false, "return the association to the node once the window is over and it is healthy")
+	clear := flags.Bool("clear", false, "remove the maintenance window")
+	flags.Parse(args)
+	services.ENVIR = *envir
+	if *node == "" {
+		return errors.New("--node is required")
+	}
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	found := false
+	for _, session := range sessions {
+		found = found || session.NodeName == *node
+	}
+	if !found {
+		return fmt.Errorf("No session %s", *node)
+	}
+	if *clear {
+		return services.UpdateSessionDrain(*node, nil)
+	}
+
+	drain := &services.Drain{From: time.Now().Unix(), Return: *back}
+	var err error
+	if *from != "" {
+		if drain.From, err = parseTime(*from); err != nil {
+			return err
+		}
+	}
+	if drain.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+	if drain.Until > 0 && drain.Until <= drain.From {
+		return errors.New("--until must be after --from")
+	}
+	if err = services.UpdateSessionDrain(*node, drain); err != nil {
+		return err
+	}
+	fmt.Printf("Drain of %s: %s\n", *node, describeDrain(drain))
+	return nil
+}
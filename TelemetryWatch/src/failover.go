@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"movrfailover/services"
@@ -16,117 +17,268 @@ type WhosActive struct {
 	Nonce   int    `json:"nonce"`   // current account nonce
 }
 
-func failover(sessAlert *services.Session, candidates []*services.Session, whosActive *map[string]*WhosActive) error {
+// incident is what triggered a failover; every reassociation attempt for it is journaled with these details
+type incident struct {
+	Detected time.Time
+	Trigger  string
+	Health   []services.NodeHealth
+	Dissent  []string // verdicts of the watchers that disagreed, with a quorum
+	Fence    string   // why the alert node is fenced before submission with FENCE_POLICY "before"; empty for never
+}
+
+var errNotConfirmed = errors.New("Reassociation was not confirmed")
+
+func failover(collator *services.Collator, sessAlert *services.Session, candidates []*services.Session, whosActive *map[string]*WhosActive, nonces map[string]int, inc *incident) error {
+	account, err := associatedAccount(collator, sessAlert, *whosActive)
+	if err != nil {
+		return err
+	}
+
+	inc.Fence = "lagging"
 	for _, sessCandidate := range candidates {
-		if !sessCandidate.NotSynced && !(*whosActive)[sessCandidate.Session].Active && sessCandidate.Priority > sessAlert.Priority && !sessAlert.Stopped {
+		if !sessCandidate.NotSynced && !isDraining(sessCandidate) && !isFenced(sessCandidate) && !(*whosActive)[sessCandidate.Session].Active && sessCandidate.Priority > sessAlert.Priority {
 			// Request updateAssociation
 			fmt.Printf("Found reassociation candidate %s for %s\n", sessCandidate.NodeName, sessAlert.NodeName)
-			fmt.Println("Extract tx from session json")
-			tx, err := extractTransaction(sessAlert, sessCandidate.NodeName, (*whosActive)[sessAlert.Session].Nonce)
+			reason := fmt.Sprintf("first synced, inactive session with priority %d above %d of %s",
+				sessCandidate.Priority, sessAlert.Priority, sessAlert.NodeName)
+			err := reassociate(collator, sessAlert, sessCandidate, candidates, nonces, account, inc, reason, nil)
+			if err == errReassociationInFlight {
+				return err
+			}
 			if err != nil {
 				fmt.Printf("%v\n", err)
 				continue
 			}
-			if tx == "" {
-				fmt.Println("Did not find a suitable transaction")
-				continue
+			if fencePolicy() == FenceAfter && !isFenced(sessAlert) {
+				fenceNode(collator, sessAlert, fmt.Sprintf("lost the association to %s: %s", sessCandidate.NodeName, inc.Fence))
 			}
+			return nil
+		}
+	}
+	return fmt.Errorf("Did not find reassociation candidate")
+}
 
-			fmt.Printf("Request reassociation to %s\n", sessCandidate.NodeName)
-			err = requestAssociation(tx)
-			if err != nil {
-				fmt.Printf("%v\n", err)
-				// Ignore
-			}
+// associatedAccount returns the collator account that sessAlert is associated with.
+// The association must belong to the collator of the job, or our proxy cannot (and must not) move it
+func associatedAccount(collator *services.Collator, sessAlert *services.Session, whosActive map[string]*WhosActive) (string, error) {
+	account := ""
+	if act, ok := whosActive[sessAlert.Session]; ok {
+		account = act.Account
+	}
+	if collator.Account != "" && account != "" && !services.SameHex(collator.Account, account) {
+		message := fmt.Sprintf(`SECURITY ALERT: %s is associated with %s instead of collator account %s, failover blocked`, sessAlert.NodeName, account, collator.Account)
+		fmt.Printf("%s\n", message)
+		notifyCollator(collator, message)
+		return "", fmt.Errorf("%s", message)
+	}
+	if collator.Account != "" {
+		account = collator.Account
+	}
+	return account, nil
+}
 
-			message := fmt.Sprintf(`Requested reassociation from %s to %s`, sessAlert.NodeName, sessCandidate.NodeName)
+/**
+reassociate makes one attempt to move the association from sessAlert to sessCandidate:
+tx selection or signing, validation, in-flight check, fencing (FENCE_POLICY "before"), submission and
+verification, all journaled.
+sessions are the sessions checked for the candidate becoming active.
+If confirm is set, it is asked before submission and nothing is submitted or journaled unless it returns true
+**/
+func reassociate(collator *services.Collator, sessAlert *services.Session, sessCandidate *services.Session, sessions []*services.Session, nonces map[string]int, account string, inc *incident, reason string, confirm func(choice *txChoice, entry *services.JournalEntry) bool) error {
+	entry := &services.JournalEntry{
+		ID:         services.NewJournalID(),
+		Collator:   collator.Name,
+		GroupName:  sessAlert.GroupName,
+		Trigger:    inc.Trigger,
+		Health:     inc.Health,
+		From:       sessAlert.NodeName,
+		To:         sessCandidate.NodeName,
+		DetectedAt: inc.Detected.Unix(),
+		Reason:     reason,
+	}
+	entry.LeaderToken = leadershipToken() // checked again right before submission
+	entry.Dissent = inc.Dissent
+	choice, err := selectTransaction(collator, sessAlert, sessCandidate, nonces, account)
+	if err != nil {
+		journalBlocked(entry, err.Error())
+		return err
+	}
+	entry.Proxy = choice.Proxy
+	entry.Nonce = choice.Nonce
+	entry.Reason += fmt.Sprintf("; proxy rank %d", choice.Rank)
+	if choice.Rank > 0 {
+		message := fmt.Sprintf(`Falling back to proxy %s (rank %d) to reassociate %s`, choice.Proxy, choice.Rank, sessAlert.NodeName)
+		fmt.Printf("%s\n", message)
+		notifyCollator(collator, message)
+	}
+	submit := requestAssociation
+	entry.Paths = []string{"presigned via REST_SESSION"}
+	if choice.Signed {
+		endpoint := services.RPCEndpoint(sessAlert.GroupName)
+		submit = func(tx string) error {
+			return submitExtrinsic(endpoint, tx)
+		}
+		entry.Paths = []string{"signed in-process via " + endpoint}
+	}
+
+	plain := choice.TX
+	// fails closed: a presigned tx that cannot be decrypted (no keyEnv, no KMS access) is not submitted
+	validate := !services.Config().SKIP_PRESIGNED_TX_VALIDATION || choice.Signed
+	if validate && !choice.Signed {
+		plain, err = services.Unwrap(choice.TX)
+		if err != nil {
+			message := fmt.Sprintf(`SECURITY ALERT: could not decrypt presigned tx from %s to %s, submission blocked: %v`, sessAlert.NodeName, sessCandidate.NodeName, err)
 			fmt.Printf("%s\n", message)
-			notifyMe(message)
-
-			isActive := false
-			for i := 0; i < 6; i++ {
-				time.Sleep(15 * time.Second)
-				fmt.Println("Checking if reassociation was successfull...")
-				whosActiveUpdated, err := getActiveSessions(candidates)
-				if err != nil {
-					fmt.Printf("%v\n", err)
-					continue
-				}
-				isActive = whosActiveUpdated[sessCandidate.Session].Active
-				if !isActive {
-					continue
-				} else {
-					break
-				}
-			}
-			if !isActive {
-				fmt.Println("Session was not activated")
-				continue
-			}
+			notifyCollator(collator, message)
+			journalBlocked(entry, message)
+			return fmt.Errorf("%s", message)
+		}
+	}
+	if validate {
+		entry.TxHash, _ = services.ExtrinsicHash(plain)
+		err = validateTransaction(plain, choice.Proxy, sessAlert, sessCandidate, choice.Nonce, account)
+		if err != nil {
+			message := fmt.Sprintf(`SECURITY ALERT: tx from %s to %s does not match the requested reassociation, submission blocked: %v`, sessAlert.NodeName, sessCandidate.NodeName, err)
+			fmt.Printf("%s\n", message)
+			notifyCollator(collator, message)
+			journalBlocked(entry, message)
+			return fmt.Errorf("%s", message)
+		}
+	}
 
-			fmt.Println("Updating local")
-			sessAlert.Stopped = true
+	if reason := reassociationInFlight(collator, sessAlert, account, true); reason != "" {
+		if confirm != nil {
+			return fmt.Errorf("%w: %s", errReassociationInFlight, reason)
+		}
+		waitForInFlight(collator, sessAlert, account, reason)
+		return errReassociationInFlight
+	}
+	if confirm != nil && !confirm(choice, entry) {
+		return errNotConfirmed
+	}
+	if err := checkLeadership(entry.LeaderToken); err != nil {
+		message := fmt.Sprintf(`Reassociation from %s to %s not submitted: %v`, sessAlert.NodeName, sessCandidate.NodeName, err)
+		fmt.Printf("%s\n", message)
+		journalBlocked(entry, message)
+		return fmt.Errorf("%s", message)
+	}
+	// fenced only now: with no valid tx to submit, the fenced node would leave the collator without an author
+	if inc.Fence != "" && fencePolicy() == FenceBefore && !isFenced(sessAlert) {
+		fenceNode(collator, sessAlert, fmt.Sprintf("losing the association to %s: %s", sessCandidate.NodeName, inc.Fence))
+	}
 
-			message = fmt.Sprintf(`Completed reassociation from %s to %s`, sessAlert.NodeName, sessCandidate.NodeName)
-			fmt.Printf("%s\n", message)
-			notifyMe(message)
-			return nil
+	fmt.Printf("Request reassociation to %s\n", sessCandidate.NodeName)
+	recordNonce(collator.Name, choice.Nonce)
+	submitted := time.Now()
+	entry.SubmittedAt = submitted.Unix()
+	entry.SelectMs = submitted.Sub(inc.Detected).Milliseconds()
+	if err := services.Journal().Append(entry); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	err = submit(choice.TX)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		entry.SubmitError = err.Error()
+		// Ignore
+	}
+
+	message := fmt.Sprintf(`Requested reassociation from %s to %s`, sessAlert.NodeName, sessCandidate.NodeName)
+	fmt.Printf("%s\n", message)
+	notifyCollator(collator, message)
+
+	isActive := false
+	for i := 0; i < 6; i++ {
+		time.Sleep(15 * time.Second)
+		fmt.Println("Checking if reassociation was successfull...")
+		whosActiveUpdated, err := getActiveSessions(sessions)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		isActive = whosActiveUpdated[sessCandidate.Session] != nil && whosActiveUpdated[sessCandidate.Session].Active
+		if !isActive {
+			continue
+		} else {
+			break
 		}
 	}
-	return fmt.Errorf("Did not find reassociation candidate")
+	entry.Outcome = services.OutcomeActivated
+	if !isActive {
+		entry.Outcome = services.OutcomeNotActivated
+	}
+	entry.CompletedAt = time.Now().Unix()
+	entry.VerifyMs = time.Since(submitted).Milliseconds()
+	if err := services.Journal().Append(entry); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	if !isActive {
+		return fmt.Errorf("Session %s was not activated", sessCandidate.NodeName)
+	}
+
+	recordFailover(collator, sessAlert, sessCandidate, choice.Nonce)
+
+	message = fmt.Sprintf(`Completed reassociation from %s to %s`, sessAlert.NodeName, sessCandidate.NodeName)
+	fmt.Printf("%s\n", message)
+	notifyCollator(collator, message)
+	return nil
+}
+
+// journalBlocked records an attempt that was stopped before submission
+func journalBlocked(entry *services.JournalEntry, reason string) {
+	entry.Outcome = services.OutcomeBlocked
+	entry.SubmitError = reason
+	entry.SubmittedAt = time.Now().Unix()
+	entry.CompletedAt = entry.SubmittedAt
+	if err := services.Journal().Append(entry); err != nil {
+		fmt.Printf("%v\n", err)
+	}
 }
 
 /**
-Raw authormapping.updateAssociation transactions are signed, enrypted, and stored in the db
-Every node (session) stores all possible transactions to reassociate to another node, for some nonces into the future
+Raw authormapping.updateAssociation transactions are signed, enrypted, and stored in the tx store (TX_BACKEND)
+Every node (session) has all possible transactions to reassociate to another node, for some nonces into the future
 The job of this function is to extract the correct transaction given the old session (the one we want to switch from),
-the new session (identified by the node name), and the current nonce
+the new session (identified by the node name), the proxy that signed it, and the current nonce of that proxy
 **/
-func extractTransaction(sessionAlert *services.Session, nodeNameReassociate string, nonce int) (string, error) {
-	type TX struct {
-		TXs   []string `json:"txs"`
-		Nonce int      `json:"nonce"`
-	}
-	nodenameToTransactions := map[string]TX{}
-	err := json.Unmarshal([]byte(sessionAlert.Transactions), &nodenameToTransactions)
+func extractTransaction(collator *services.Collator, sessionAlert *services.Session, nodeNameReassociate string, proxy string, nonce int) (string, error) {
+	presigned, err := services.Txs().Lookup(collator.Name, sessionAlert, nodeNameReassociate, proxy, nonce)
 	if err != nil {
 		return "", err
 	}
-	for nName, txNonce := range nodenameToTransactions {
-		if nodeNameReassociate == nName {
-			for i, tx := range txNonce.TXs {
-				if nonce == txNonce.Nonce+i {
-					return tx, nil
-				}
-			}
-		}
+	if presigned == nil {
+		return "", nil // no tx for this pair, proxy and nonce; may need to run offline tx maker for new sessions
+	}
+	window := presigned.Window
+	if err := txsMatchRuntime(window.SpecVersion, window.TransactionVersion, currentRuntime(sessionAlert.GroupName)); err != nil {
+		return "", fmt.Errorf("Presigned txs from %s to %s are stale: %v", sessionAlert.NodeName, nodeNameReassociate, err)
 	}
-	return "", nil // did not find raw transaction; may need to run offline tx maker for new sessions
+	return presigned.TX, nil
 }
 
-func requestAssociation(tx string) error {
-	apiKey, keyCaller, err := services.GetKeys()
-	if err != nil {
-		return err
-	}
-	var jsonstr = []byte(fmt.Sprintf(`{"tx":"%s","keyCaller":"%s"}`, tx, keyCaller))
-	err = httpPost(jsonstr, nil, apiKey, services.Config().REST_SESSION)
+// submitExtrinsic sends a plain (unencrypted) signed extrinsic straight to a node
+func submitExtrinsic(endpoint string, tx string) error {
+	var hash string
+	err := services.RPCCall(endpoint, "author_submitExtrinsic", []interface{}{tx}, &hash)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("Submitted extrinsic %s\n", hash)
 	return nil
 }
 
+func requestAssociation(tx string) error {
+	return withKeys(func(keys *services.SecretKey) error {
+		var jsonstr = []byte(fmt.Sprintf(`{"tx":"%s","keyCaller":"%s"}`, tx, keys.KeyCaller))
+		return httpPost(jsonstr, nil, keys.ApiKey, services.Config().REST_SESSION)
+	})
+}
+
 /**
 This method calls a REST endpoint that accepts a list of session strings,
 and returns which of these sessions are active, and the associated account
 (public address) and their nonces for these active sessions
 **/
 func getActiveSessions(sessions []*services.Session) (map[string]*WhosActive, error) {
-	apiKey, _, err := services.GetKeys()
-	if err != nil {
-		return nil, err
-	}
 	type WhosActiveRequest struct {
 		Sessions []string `json:"sessions"`
 	}
@@ -141,7 +293,9 @@ func getActiveSessions(sessions []*services.Session) (map[string]*WhosActive, er
 		return nil, err
 	}
 	answer := map[string]*WhosActive{}
-	err = httpPost(jsonstr, &answer, apiKey, services.Config().REST_WHOS_SESSION)
+	err = withKeys(func(keys *services.SecretKey) error {
+		return httpPost(jsonstr, &answer, keys.ApiKey, services.Config().REST_WHOS_SESSION)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -149,10 +303,6 @@ func getActiveSessions(sessions []*services.Session) (map[string]*WhosActive, er
 }
 
 func getAccountNonces(accounts []string) (map[string]int, error) {
-	apiKey, _, err := services.GetKeys()
-	if err != nil {
-		return nil, err
-	}
 	type NoncesRequest struct {
 		Accounts []string `json:"accounts"`
 	}
@@ -167,7 +317,9 @@ func getAccountNonces(accounts []string) (map[string]int, error) {
 		return nil, err
 	}
 	answer := map[string]int{}
-	err = httpPostNonces(jsonstr, &answer, apiKey, services.Config().REST_WHOS_SESSION)
+	err = withKeys(func(keys *services.SecretKey) error {
+		return httpPostNonces(jsonstr, &answer, keys.ApiKey, services.Config().REST_WHOS_SESSION)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -191,6 +343,9 @@ func httpPost(jsonstr []byte, answer *map[string]*WhosActive, apiKey string, end
 	// fmt.Println("response Headers:", resp.Header)
 	body, _ := ioutil.ReadAll(resp.Body)
 	fmt.Println("response Body:", string(body))
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errKeysRefused
+	}
 	if resp.Status == "200 OK" && answer != nil {
 		err = json.Unmarshal(body, &answer)
 	}
@@ -214,6 +369,9 @@ func httpPostNonces(jsonstr []byte, answer *map[string]int, apiKey string, endpo
 	// fmt.Println("response Headers:", resp.Header)
 	body, _ := ioutil.ReadAll(resp.Body)
 	fmt.Println("response Body:", string(body))
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errKeysRefused
+	}
 	if resp.Status == "200 OK" && answer != nil {
 		err = json.Unmarshal(body, &answer)
 	}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"movrfailover/services"
+	"time"
+)
+
+// Fence policies: when the node that lost the association is fenced
+const (
+	FenceNone   = "none"
+	FenceBefore = "before" // before the reassociation is submitted
+	FenceAfter  = "after"  // once the candidate is verified active
+)
+
+func fencePolicy() string {
+	if services.Config().FENCE_POLICY == "" {
+		return FenceNone
+	}
+	return services.Config().FENCE_POLICY
+}
+
+// seedFences restores the fenced state of nodes recorded in the db
+func seedFences(sessions []*services.Session) {
+	for _, session := range sessions {
+		if session.Fence != nil {
+			setNodeState(session.NodeName, NodeFenced, session.Fence.Reason)
+		}
+	}
+}
+
+// refreshFence follows fences set or cleared in the db by the fence command
+func refreshFence(session *services.Session) {
+	state := nodeStateOf(session.NodeName).State
+	if session.Fence != nil && !isFenceState(state) {
+		setNodeState(session.NodeName, NodeFenced, session.Fence.Reason)
+	} else if session.Fence == nil && (state == NodeFenced || state == NodeFenceFailed) {
+		unfenceNode(session.NodeName, "fence cleared")
+	}
+}
+
+/**
+fenceNode runs the FENCE_ACTIONS against a node so it cannot keep authoring next to its replacement.
+Every action is run even if an earlier one fails. The result is kept in the node state machine and in the db,
+so the node stays out of candidacy until it is unfenced with `movrfailover fence --clear`
+**/
+func fenceNode(collator *services.Collator, session *services.Session, reason string) error {
+	setNodeState(session.NodeName, NodeFencing, reason)
+	fence := &services.Fence{At: time.Now().Unix(), Reason: reason}
+	for _, config := range services.Config().FENCE_ACTIONS {
+		action, err := newAction(config)
+		if err == nil {
+			err = action.Run(session)
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			fence.Error = err.Error()
+		}
+	}
+
+	state := NodeFenced
+	message := fmt.Sprintf(`Fenced %s: %s`, session.NodeName, reason)
+	if fence.Error != "" {
+		state = NodeFenceFailed
+		message = fmt.Sprintf(`Fencing of %s failed: %s`, session.NodeName, fence.Error)
+	}
+	setNodeState(session.NodeName, state, reason)
+	if err := services.UpdateSessionFence(session.NodeName, fence); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	fmt.Printf("%s\n", message)
+	notifyCollator(collator, message)
+	if fence.Error != "" {
+		return errors.New(message)
+	}
+	return nil
+}
+
+// fenceCommand fences a node by hand, or unfences it so it can be a candidate again
+func fenceCommand(args []string) error {
+	flags := flag.NewFlagSet("fence", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	node := flags.String("node", "", "node name")
+	reason := flags.String("reason", "fenced by hand", "reason recorded with the fence")
+	clear := flags.Bool("clear", false, "unfence the node")
+	flags.Parse(args)
+	services.ENVIR = *envir
+	if *node == "" {
+		return errors.New("--node is required")
+	}
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.NodeName != *node {
+			continue
+		}
+		if *clear {
+			return services.UpdateSessionFence(*node, nil)
+		}
+		collator, err := services.CollatorOf(session)
+		if err != nil {
+			return err
+		}
+		err = fenceNode(collator, session, *reason)
+		sendQueuedAlerts()
+		return err
+	}
+	return fmt.Errorf("No session %s", *node)
+}
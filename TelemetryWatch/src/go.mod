@@ -7,11 +7,13 @@ require (
 	github.com/aws/aws-sdk-go v1.36.29
 	github.com/chilts/sid v0.0.0-20190607042430-660e94789ec9
 	github.com/chrisxue815/realworld-aws-lambda-dynamodb-go v0.0.0-20200506011653-8dec498a3fed
-	github.com/ghodss/yaml v1.0.0 // indirect
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.2.0
+	github.com/ghodss/yaml v1.0.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/jinzhu/copier v0.2.3 // indirect
 	github.com/k3a/html2text v1.0.8
 	github.com/recws-org/recws v1.2.2 // indirect
 	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/tkanos/gonfig v0.0.0-20210106201359-53e13348de2f
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"movrfailover/services"
+	"time"
+)
+
+var errKeysRefused = errors.New("api key refused")
+
+/**
+withKeys calls the REST API with the cached keys. When they are refused, the secret may have been rotated:
+the call is retried with freshly fetched keys and then, in case the API still expects them, with the
+previous version of the secret, so a rotation never fails a reassociation in flight
+**/
+func withKeys(call func(keys *services.SecretKey) error) error {
+	keys, err := services.Keys()
+	if err != nil {
+		return err
+	}
+	if err = call(keys); !errors.Is(err, errKeysRefused) {
+		return err
+	}
+	fmt.Println("Keys refused, fetching them again")
+	fresh, ferr := services.RefreshKeys()
+	if ferr != nil {
+		return err
+	}
+	if *fresh != *keys {
+		if err = call(fresh); !errors.Is(err, errKeysRefused) {
+			return err
+		}
+	}
+	previous, perr := services.PreviousKeys()
+	if perr != nil || previous == nil || *previous == *fresh {
+		return err
+	}
+	fmt.Println("Current keys refused, trying the previous version")
+	return call(previous)
+}
+
+// keepKeysFresh fetches the keys ahead of their TTL, so calls during a failover are served from the cache
+func keepKeysFresh() {
+	period := time.Duration(services.Config().SECRETS_TTL_IN_SECONDS) * time.Second / 2
+	if period <= 0 {
+		period = 150 * time.Second
+	}
+	for {
+		time.Sleep(period)
+		if _, err := services.RefreshKeys(); err != nil {
+			fmt.Printf("Cannot refresh the keys: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"movrfailover/services"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var electing = false  // true once this process takes part in the election
+var leading = true    // false while another watcher holds the lease
+var leaderToken int64 // fencing token of our lease, 0 without HA
+var leaderHolder = "" // last known holder of the lease
+var ldMX sync.RWMutex // mx for electing, leading, leaderToken and leaderHolder
+var errNotLeader = errors.New("this watcher is not the leader")
+
+// minLeaseTTL leaves the leader time to renew (every third of the ttl) across a slow lease table
+const minLeaseTTL = 6 * time.Second
+
+/**
+With LEASE_BACKEND set, several watchers run side by side and the one holding the lease leads.
+Followers keep following telemetry and the session store, so they are warm when they take over,
+but only the leader remediates, drains, fails over, saves runtime state and sends alerts.
+Commands (switch, drain...) do not take part in the election and always act
+**/
+func electLeader() error {
+	if services.Leases() == nil {
+		return nil
+	}
+	if leaseTTL() < minLeaseTTL {
+		return fmt.Errorf("LEASE_TTL_IN_SECONDS is %d, it must be at least %d with LEASE_BACKEND set",
+			services.Config().LEASE_TTL_IN_SECONDS, int(minLeaseTTL.Seconds()))
+	}
+	ldMX.Lock()
+	electing = true
+	leading = false
+	ldMX.Unlock()
+	renewLeadership()
+	go releaseOnSignal()
+	return nil
+}
+
+// keepLeadership renews (or tries to take) the lease every third of its ttl
+func keepLeadership() {
+	if services.Leases() == nil {
+		return
+	}
+	for {
+		time.Sleep(leaseTTL() / 3)
+		renewLeadership()
+	}
+}
+
+func leaseTTL() time.Duration {
+	return time.Duration(services.Config().LEASE_TTL_IN_SECONDS) * time.Second
+}
+
+func renewLeadership() {
+	id := services.WatcherID()
+	lease, err := services.Leases().Acquire(id, leaseTTL())
+	ldMX.Lock()
+	wasLeading := leading
+	previous := leaderHolder
+	if err != nil {
+		// we cannot tell whether we still hold the lease; stop acting before it may expire
+		fmt.Printf("%v\n", err)
+		leading = false
+		ldMX.Unlock()
+		if wasLeading {
+			queueAlert(fmt.Sprintf(`Watcher %s cannot renew its lease and stopped acting: %v`, id, err))
+		}
+		return
+	}
+	leading = lease.HeldBy(id, time.Now().Unix())
+	leaderHolder = lease.Holder
+	leaderToken = 0
+	if leading {
+		leaderToken = lease.Token
+	}
+	ldMX.Unlock()
+
+	switch {
+	case leading && !wasLeading:
+		message := fmt.Sprintf(`Watcher %s is now the leader (token %d)`, id, lease.Token)
+		if previous != "" && previous != id {
+			message += fmt.Sprintf(`, taking over from %s`, previous)
+		}
+		fmt.Printf("%s\n", message)
+		notifyMe(message)
+	case !leading && wasLeading:
+		message := fmt.Sprintf(`Watcher %s lost the lease to %s`, id, lease.Holder)
+		fmt.Printf("%s\n", message)
+		queueAlert(message)
+	case lease.Holder != previous:
+		fmt.Printf("Following %s (token %d)\n", lease.Holder, lease.Token)
+	}
+}
+
+// releaseOnSignal hands the lease over at once when the watcher is shut down
+func releaseOnSignal() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	releaseLeadership()
+	os.Exit(0)
+}
+
+func releaseLeadership() {
+	if err := services.Leases().Release(services.WatcherID()); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	ldMX.Lock()
+	leading = false
+	leaderToken = 0
+	ldMX.Unlock()
+}
+
+func isLeader() bool {
+	ldMX.RLock()
+	defer ldMX.RUnlock()
+	return leading
+}
+
+func leadershipToken() int64 {
+	ldMX.RLock()
+	defer ldMX.RUnlock()
+	return leaderToken
+}
+
+/**
+checkLeadership verifies in the lease store, right before a submission, that the lease is still ours
+under the token the failover started with. A watcher that paused (GC, network) past its lease
+cannot submit next to the new leader
+**/
+func checkLeadership(token int64) error {
+	ldMX.RLock()
+	isElecting := electing
+	ldMX.RUnlock()
+	if !isElecting {
+		return nil
+	}
+	if token == 0 {
+		return errNotLeader
+	}
+	lease, err := services.Leases().Get()
+	if err != nil {
+		return err
+	}
+	if lease == nil {
+		return errNotLeader
+	}
+	if !lease.HeldBy(services.WatcherID(), time.Now().Unix()) || lease.Token != token {
+		return fmt.Errorf("%w: lease is held by %s with token %d, we acted under token %d", errNotLeader, lease.Holder, lease.Token, token)
+	}
+	return nil
+}
@@ -11,6 +11,9 @@ type TelemetryMessages []interface{}
 
 func main() {
 
+	if runCommand() {
+		return
+	}
 	if len(os.Args) > 1 {
 		services.ENVIR = os.Args[1]
 	}
@@ -23,15 +26,20 @@ func main() {
 		panic(err)
 	}
 
-	sessionGroups := map[string][]*services.Session{}
+	sessions = checkSessions(sessions)
 	fmt.Println("Loaded sessions:")
-	for _, session := range sessions {
-		if _, ok := sessionGroups[session.GroupName]; !ok {
-			sessionGroups[session.GroupName] = []*services.Session{}
-		}
-		sessionGroups[session.GroupName] = append(sessionGroups[session.GroupName], session)
-		fmt.Printf("Loaded session: %+v\n", session.NodeName)
+	sessionGroups, jobs := loadJobs(sessions)
+	configureSigner()
+	restoreRuntime(sessions)
+	seedDrains(sessions)
+	seedFences(sessions)
+
+	// Fetch the keys of the REST API now and keep them cached, rather than during a failover
+	if err = services.PrefetchKeys(); err != nil {
+		fmt.Printf("Cannot prefetch the keys: %v\n", err)
 	}
+	go keepKeysFresh()
+	checkValidation()
 
 	// Send email and SMS alerts as they are submitted to the alert queue
 	go processAlerts()
@@ -39,12 +47,23 @@ func main() {
 	// Report status on screen every X seconds
 	go reportStatus()
 
-	// Launch a delegator for every group (network)
-	// If a new group is added to the DB, then program must restart;
-	// however, it's ok to add/remove sesison entries to existing groups in the DB
-	for groupName, sessions := range sessionGroups {
-		go delegate(groupName, sessions)
+	// With several watchers, only the one holding the lease acts
+	if err = electLeader(); err != nil {
+		panic(err)
 	}
+	go keepLeadership()
+
+	// Launch a delegator for every collator in every group (network)
+	startJobs(sessionGroups, jobs)
+
+	// Reload sessions, groups, maintenance windows and fences as they change in the session store
+	go watchSessions()
+
+	// Save the runtime state of the nodes, restored by restoreRuntime on the next start
+	go persistRuntime()
+
+	// Follow runtime upgrades that invalidate presigned transactions
+	go watchRuntime()
 
 	// Read messages off queue and watch for lagging nodes
 	go watch()
@@ -54,3 +73,36 @@ func main() {
 
 	<-(chan int)(nil) // wait forever
 }
+
+// loadJobs groups the sessions by group (network) and by collator within each group
+func loadJobs(sessions []*services.Session) (map[string][]*services.Session, []*failoverJob) {
+	sessionGroups := map[string][]*services.Session{}
+	jobs := []*failoverJob{}
+	jobsByKey := map[string]*failoverJob{}
+	for _, session := range sessions {
+		collator, err := services.CollatorOf(session)
+		if err != nil {
+			fmt.Printf("Skipping session: %v\n", err)
+			continue
+		}
+		if session.Proxy == "" && len(collator.Proxies) > 0 {
+			session.Proxy = collator.Proxies[0]
+		} else if len(collator.Proxies) > 0 && !collator.HasProxy(session.Proxy) {
+			fmt.Printf("Skipping session: %s has proxy %s which is not a proxy of collator %s\n", session.NodeName, session.Proxy, collator.Name)
+			continue
+		}
+		if _, ok := sessionGroups[session.GroupName]; !ok {
+			sessionGroups[session.GroupName] = []*services.Session{}
+		}
+		sessionGroups[session.GroupName] = append(sessionGroups[session.GroupName], session)
+
+		key := collator.Name + "/" + session.GroupName
+		if _, ok := jobsByKey[key]; !ok {
+			jobsByKey[key] = &failoverJob{Collator: collator, GroupName: session.GroupName, stop: make(chan struct{})}
+			jobs = append(jobs, jobsByKey[key])
+		}
+		jobsByKey[key].Sessions = append(jobsByKey[key].Sessions, session)
+		fmt.Printf("Loaded session: %+v (%s)\n", session.NodeName, key)
+	}
+	return sessionGroups, jobs
+}
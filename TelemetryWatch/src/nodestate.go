@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+	"sync"
+	"time"
+)
+
+// Node states
+const (
+	NodeHealthy     = "healthy"
+	NodeLagging     = "lagging"
+	NodeFencing     = "fencing"
+	NodeFenced      = "fenced"
+	NodeFenceFailed = "fence failed"
+)
+
+// nodeState is where a node is in its lifecycle, as seen by the watcher
+type nodeState struct {
+	State  string
+	Since  int64 // unix seconds
+	Reason string
+}
+
+var nodeStates = map[string]*nodeState{} // nodeName -> state
+var nsMX sync.RWMutex                    // mx for nodeStates
+
+/**
+setNodeState moves a node to a new state. Fenced nodes (including failed fences) only leave
+their state through unfenceNode, so telemetry cannot bring them back into candidacy
+**/
+func setNodeState(nodeName string, state string, reason string) {
+	nsMX.Lock()
+	defer nsMX.Unlock()
+	current, ok := nodeStates[nodeName]
+	if ok && current.State == state {
+		return
+	}
+	if ok && isFenceState(current.State) && !isFenceState(state) {
+		return
+	}
+	if ok {
+		fmt.Printf("Node %s: %s -> %s (%s)\n", nodeName, current.State, state, reason)
+	}
+	nodeStates[nodeName] = &nodeState{State: state, Since: time.Now().Unix(), Reason: reason}
+}
+
+// unfenceNode returns a fenced node to the healthy state; the next block check re-evaluates it
+func unfenceNode(nodeName string, reason string) {
+	nsMX.Lock()
+	defer nsMX.Unlock()
+	fmt.Printf("Node %s: unfenced (%s)\n", nodeName, reason)
+	nodeStates[nodeName] = &nodeState{State: NodeHealthy, Since: time.Now().Unix(), Reason: reason}
+}
+
+func isFenceState(state string) bool {
+	return state == NodeFencing || state == NodeFenced || state == NodeFenceFailed
+}
+
+func nodeStateOf(nodeName string) nodeState {
+	nsMX.RLock()
+	defer nsMX.RUnlock()
+	if state, ok := nodeStates[nodeName]; ok {
+		return *state
+	}
+	return nodeState{State: NodeHealthy}
+}
+
+// isFenced reports whether a node was (or is being) fenced; such a node is never a candidate
+func isFenced(session *services.Session) bool {
+	return isFenceState(nodeStateOf(session.NodeName).State)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"movrfailover/services"
+	"sync"
+	"time"
+)
+
+var errReassociationInFlight = errors.New("A reassociation is already in flight")
+
+// includedTxs holds the reassociations found in a recent block, so each one postpones a single attempt
+var includedTxs = map[string]int64{} // tx hash -> unix seconds when it was found
+var itMX sync.Mutex                  // mx for includedTxs
+
+// firstSeenIncluded records an included reassociation, false if an earlier check found it already
+func firstSeenIncluded(tx string) bool {
+	hash, err := services.ExtrinsicHash(tx)
+	if err != nil {
+		return true
+	}
+	itMX.Lock()
+	defer itMX.Unlock()
+	now := time.Now().Unix()
+	for seen, at := range includedTxs {
+		if now-at > 3600 { // long out of the recent blocks
+			delete(includedTxs, seen)
+		}
+	}
+	if _, ok := includedTxs[hash]; ok {
+		return false
+	}
+	includedTxs[hash] = now
+	return true
+}
+
+/**
+If the watcher restarts in the middle of a failover, or two group loops of a collator overlap,
+a second reassociation could be sent while the first one is still in the tx pool.
+Before submitting we look for a reassociation of the collator that is
+- journaled as submitted and not yet verified (within PENDING_TX_TIMEOUT_IN_SECONDS),
+- waiting in the tx pool of our node, or
+- included in the last RECENT_BLOCKS_TO_CHECK blocks and not found by an earlier check: the attempt
+  is postponed once, so the next one decides with who is active after that tx.
+It returns a description of what it found, or "" if nothing is in flight.
+Chain checks are best effort: if the node cannot be queried we rely on the journal alone
+**/
+func reassociationInFlight(collator *services.Collator, sessAlert *services.Session, account string, checkBlocks bool) string {
+	since := time.Now().Unix() - int64(services.Config().PENDING_TX_TIMEOUT_IN_SECONDS)
+	entries, err := services.Journal().Query(collator.Name, since, 0)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	for _, entry := range entries {
+		if entry.Kind == services.KindReassociation && entry.Outcome == services.OutcomeInFlight {
+			return fmt.Sprintf("journaled %s -> %s at nonce %d is not verified yet", entry.From, entry.To, entry.Nonce)
+		}
+	}
+
+	proxies := collator.ProxiesFor(sessAlert)
+	endpoint := services.RPCEndpoint(sessAlert.GroupName)
+	pending, err := services.GetPendingExtrinsics(endpoint)
+	if err != nil {
+		fmt.Printf("Could not read pending extrinsics: %v\n", err)
+	}
+	for _, tx := range pending {
+		if ext := reassociationOf(tx, proxies, account); ext != nil {
+			return fmt.Sprintf("tx of proxy %s at nonce %d is in the tx pool", ext.Signer, ext.Nonce)
+		}
+	}
+
+	if !checkBlocks {
+		return ""
+	}
+	best, err := services.GetBestBlockNumber(endpoint)
+	if err != nil {
+		fmt.Printf("Could not read best block: %v\n", err)
+		return ""
+	}
+	for number := best; number > best-services.Config().RECENT_BLOCKS_TO_CHECK && number >= 0; number-- {
+		txs, err := services.GetBlockExtrinsics(endpoint, number)
+		if err != nil {
+			fmt.Printf("Could not read block %d: %v\n", number, err)
+			return ""
+		}
+		for _, tx := range txs {
+			if ext := reassociationOf(tx, proxies, account); ext != nil && firstSeenIncluded(tx) {
+				return fmt.Sprintf("tx of proxy %s at nonce %d was included in block %d", ext.Signer, ext.Nonce, number)
+			}
+		}
+	}
+	return ""
+}
+
+// reassociationOf returns the decoded extrinsic if tx is an authorMapping call made by one of proxies for account
+func reassociationOf(tx string, proxies []string, account string) *services.Extrinsic {
+	ext, err := services.DecodeExtrinsic(tx)
+	if err != nil {
+		return nil // unsigned (inherents) or not ours
+	}
+	signedByProxy := false
+	for _, proxy := range proxies {
+		signedByProxy = signedByProxy || services.SameHex(ext.Signer, proxy)
+	}
+	if !signedByProxy {
+		return nil
+	}
+	proxyCall, err := ext.Call.DecodeProxy()
+	if err != nil {
+		return nil
+	}
+	if account != "" && !services.SameHex(proxyCall.Real, account) {
+		return nil
+	}
+	if proxyCall.Call.Pallet != services.AuthorMappingPalletIndex {
+		return nil
+	}
+	return ext
+}
+
+// waitForInFlight waits until the reassociation found in flight leaves the tx pool and the journal,
+// or PENDING_TX_TIMEOUT_IN_SECONDS passes. The caller then re-evaluates with fresh chain state
+func waitForInFlight(collator *services.Collator, sessAlert *services.Session, account string, reason string) {
+	message := fmt.Sprintf(`Reassociation of %s postponed: %s; waiting for its outcome`, sessAlert.NodeName, reason)
+	fmt.Printf("%s\n", message)
+	notifyCollator(collator, message)
+
+	deadline := time.Now().Add(time.Duration(services.Config().PENDING_TX_TIMEOUT_IN_SECONDS) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(6 * time.Second)
+		if reassociationInFlight(collator, sessAlert, account, false) == "" {
+			return
+		}
+	}
+	fmt.Printf("Reassociation of %s still in flight after %ds\n", sessAlert.NodeName, services.Config().PENDING_TX_TIMEOUT_IN_SECONDS)
+}
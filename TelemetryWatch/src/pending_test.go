@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"movrfailover/services"
+)
+
+// an included reassociation postpones one attempt; the next check looks past it
+func TestFirstSeenIncluded(t *testing.T) {
+	if !firstSeenIncluded(presignedTx) {
+		t.Fatal("an included reassociation was not reported the first time")
+	}
+	if firstSeenIncluded(presignedTx) {
+		t.Error("an included reassociation was reported twice")
+	}
+	if _, ok := includedTxs[mustHash(t, presignedTx)]; !ok {
+		t.Error("the included reassociation is not recorded by hash")
+	}
+}
+
+func mustHash(t *testing.T, tx string) string {
+	hash, err := services.ExtrinsicHash(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"movrfailover/services"
+)
+
+// txChoice is the transaction picked for a reassociation and the proxy that signed it
+type txChoice struct {
+	Proxy  string
+	Rank   int // position of Proxy in the collator's ranked proxies; 0 is the primary
+	Nonce  int
+	TX     string
+	Signed bool // true if signed in-process (plain extrinsic), false if presigned (encrypted)
+}
+
+/**
+A collator may have several AuthorMapping proxies, ranked. We walk them in order and pick the first one
+that can pay the fees and for which we have either a presigned tx at its current nonce or a signer key.
+Proxies without a known nonce (lookup failed) are skipped. The balance check is best effort:
+if it cannot be done the proxy is assumed to be funded
+**/
+func selectTransaction(collator *services.Collator, sessAlert *services.Session, sessCandidate *services.Session, nonces map[string]int, account string) (*txChoice, error) {
+	endpoint := services.RPCEndpoint(sessAlert.GroupName)
+	for rank, proxy := range collator.ProxiesFor(sessAlert) {
+		nonce, ok := nonces[proxy]
+		if !ok {
+			fmt.Printf("No nonce for proxy %s\n", proxy)
+			continue
+		}
+		if !proxyFunded(endpoint, proxy) {
+			continue
+		}
+
+		fmt.Printf("Extract tx of proxy %s from the tx store\n", proxy)
+		tx, err := extractTransaction(collator, sessAlert, sessCandidate.NodeName, proxy, nonce)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+		} else if tx == "" {
+			fmt.Println("Did not find a suitable transaction")
+		}
+		if tx != "" {
+			return &txChoice{Proxy: proxy, Rank: rank, Nonce: nonce, TX: tx}, nil
+		}
+
+		if !services.Config().SIGNER_FALLBACK || signer == nil || !signer.HasKey(proxy) {
+			continue
+		}
+		fmt.Println("Signing reassociation in-process")
+		tx, err = signer.SignReassociation(sessAlert, sessCandidate, proxy, nonce, account)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		return &txChoice{Proxy: proxy, Rank: rank, Nonce: nonce, TX: tx, Signed: true}, nil
+	}
+	return nil, fmt.Errorf("No proxy of %s can reassociate %s to %s", collator.Name, sessAlert.NodeName, sessCandidate.NodeName)
+}
+
+func proxyFunded(endpoint string, proxy string) bool {
+	if services.Config().PROXY_MIN_BALANCE == "" {
+		return true
+	}
+	minBalance, ok := new(big.Int).SetString(services.Config().PROXY_MIN_BALANCE, 10)
+	if !ok {
+		fmt.Printf("Invalid PROXY_MIN_BALANCE %s\n", services.Config().PROXY_MIN_BALANCE)
+		return true
+	}
+	balance, err := services.GetBalance(endpoint, proxy)
+	if err != nil {
+		fmt.Printf("Could not check balance of proxy %s, assuming funded: %v\n", proxy, err)
+		return true
+	}
+	if balance.Cmp(minBalance) < 0 {
+		fmt.Printf("Proxy %s has %s wei, below the minimum of %s\n", proxy, balance, minBalance)
+		return false
+	}
+	return true
+}
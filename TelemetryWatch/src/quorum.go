@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+	"strings"
+	"time"
+)
+
+/**
+With VOTE_BACKEND set, every watcher (leader or follower) publishes its verdict on each node at every
+block check. The leader remediates or reassociates an active node only when at least QUORUM watchers found
+it unhealthy recently, so one watcher with a bad view of the network cannot restart the node or move the
+association on its own.
+The verdicts of the watchers that disagreed are journaled, whether the quorum was reached or not
+**/
+func publishVerdicts(verdicts []*services.Verdict) {
+	if services.Votes() == nil {
+		return
+	}
+	for _, verdict := range verdicts {
+		if err := services.Votes().Publish(verdict); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+	}
+}
+
+// newVerdict records the verdict of this watcher on a node; the caller holds niMX and nfMX
+func newVerdict(session *services.Session, healthy bool, reason string) *services.Verdict {
+	return &services.Verdict{
+		NodeName:  session.NodeName,
+		Watcher:   services.WatcherID(),
+		Healthy:   healthy,
+		Reason:    reason,
+		Imported:  nodeImported[session.NodeName],
+		Finalized: nodeFinalized[session.NodeName],
+		At:        time.Now().Unix(),
+	}
+}
+
+// confirmQuorum returns the lagging active nodes that enough watchers agree on, and the dissent on each
+func confirmQuorum(job *failoverJob, onAlert []*services.Session, lagOf map[string]string) ([]*services.Session, map[string][]string) {
+	dissent := map[string][]string{}
+	if services.Votes() == nil || services.Config().QUORUM <= 1 {
+		return onAlert, dissent
+	}
+	since := time.Now().Unix() - int64(services.Config().VOTE_TTL_IN_SECONDS)
+	agreed := []*services.Session{}
+	for _, session := range onAlert {
+		verdicts, err := services.Votes().Verdicts(session.NodeName, since)
+		if err != nil {
+			fmt.Printf("%v\n", err) // no quorum without votes; retried at the next block check
+			continue
+		}
+		unhealthy := 0
+		for _, verdict := range verdicts {
+			if verdict.Healthy {
+				dissent[session.NodeName] = append(dissent[session.NodeName], verdict.String())
+			} else {
+				unhealthy++
+			}
+		}
+		if unhealthy >= services.Config().QUORUM {
+			agreed = append(agreed, session)
+			continue
+		}
+		quorumNotReached(job, session, lagOf[session.NodeName], unhealthy, dissent[session.NodeName])
+	}
+	return agreed, dissent
+}
+
+// quorumNotReached journals and alerts (once per chill period) a reassociation held back by the quorum
+func quorumNotReached(job *failoverJob, session *services.Session, trigger string, unhealthy int, dissent []string) {
+	reason := fmt.Sprintf(`only %d of %d watchers found %s unhealthy`, unhealthy, services.Config().QUORUM, session.NodeName)
+	fmt.Printf("Quorum not reached: %s\n", reason)
+	key := "quorum/" + session.NodeName
+	naMX.Lock()
+	chilled := notifiedAt[key] >= int(time.Now().Unix())-services.Config().ALERT_CHILL_PERIOD_IN_MINUTES*60
+	if !chilled {
+		notifiedAt[key] = int(time.Now().Unix())
+	}
+	naMX.Unlock()
+	if chilled {
+		return
+	}
+	entry := &services.JournalEntry{
+		ID:          services.NewJournalID(),
+		Collator:    job.Collator.Name,
+		GroupName:   job.GroupName,
+		Trigger:     trigger,
+		From:        session.NodeName,
+		DetectedAt:  time.Now().Unix(),
+		Dissent:     dissent,
+		LeaderToken: leadershipToken(),
+	}
+	journalBlocked(entry, "quorum not reached: "+reason)
+	message := fmt.Sprintf(`Reassociation of %s held back, %s`, session.NodeName, reason)
+	if len(dissent) > 0 {
+		message += "; " + strings.Join(dissent, "; ")
+	}
+	notifyCollator(job.Collator, message)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+	"strings"
+	"sync"
+	"time"
+)
+
+var runningJobs = map[string]*failoverJob{}          // job key -> running delegator
+var runningGroups = map[string][]*services.Session{} // groupName -> sessions of all its jobs
+var rjMX sync.RWMutex                                // mx for runningJobs and runningGroups
+
+// startJobs launches a delegator for every job; used at startup
+func startJobs(sessionGroups map[string][]*services.Session, jobs []*failoverJob) {
+	rjMX.Lock()
+	defer rjMX.Unlock()
+	for _, job := range jobs {
+		runningJobs[job.Key()] = job
+		go delegate(job)
+	}
+	runningGroups = sessionGroups
+}
+
+func currentJobs() []*failoverJob {
+	rjMX.RLock()
+	defer rjMX.RUnlock()
+	jobs := []*failoverJob{}
+	for _, job := range runningJobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func currentSessionGroups() map[string][]*services.Session {
+	rjMX.RLock()
+	defer rjMX.RUnlock()
+	groups := map[string][]*services.Session{}
+	for groupName, sessions := range runningGroups {
+		groups[groupName] = sessions
+	}
+	return groups
+}
+
+// watchSessions applies every change of the session store while we run
+func watchSessions() {
+	period := time.Duration(services.Config().RELOAD_PERIOD_IN_SECONDS) * time.Second
+	for sessions := range services.Sessions().Watch(period) {
+		sessions = checkSessions(sessions)
+		refreshMaintenance(sessions)
+		reloadSessions(sessions) // the first list is the one we started with; unchanged, it is a no-op
+	}
+}
+
+/**
+reloadSessions merges a fresh list of sessions into the running jobs.
+Jobs of new groups (or collators) start a delegator, jobs without sessions are stopped.
+Unchanged sessions keep their object, changed ones keep their runtime state (NotSynced),
+so a reload never makes the watcher forget what it saw. Every change is alerted
+**/
+func reloadSessions(sessions []*services.Session) {
+	sessionGroups, jobs := loadJobs(sessions)
+	changes := []string{}
+
+	rjMX.Lock()
+	fresh := map[string]bool{}
+	for _, job := range jobs {
+		fresh[job.Key()] = true
+		running, ok := runningJobs[job.Key()]
+		if !ok {
+			runningJobs[job.Key()] = job
+			go delegate(job)
+			changes = append(changes, fmt.Sprintf("started %s with %d sessions", job.Key(), len(job.Sessions)))
+			continue
+		}
+		merged, jobChanges := mergeSessions(running.sessions(), job.Sessions)
+		running.setSessions(merged)
+		for _, change := range jobChanges {
+			changes = append(changes, job.Key()+": "+change)
+		}
+	}
+	for key, job := range runningJobs {
+		if !fresh[key] {
+			close(job.stop)
+			delete(runningJobs, key)
+			changes = append(changes, fmt.Sprintf("stopped %s, it has no sessions left", key))
+		}
+	}
+	runningGroups = map[string][]*services.Session{}
+	for groupName := range sessionGroups {
+		for _, job := range runningJobs {
+			if job.GroupName == groupName {
+				runningGroups[groupName] = append(runningGroups[groupName], job.sessions()...)
+			}
+		}
+	}
+	rjMX.Unlock()
+
+	for _, change := range changes {
+		message := "Sessions reloaded: " + change
+		fmt.Printf("%s\n", message)
+		notifyMe(message)
+	}
+}
+
+// mergeSessions keeps the running object of unchanged sessions and the runtime state of changed ones
+func mergeSessions(running []*services.Session, fresh []*services.Session) ([]*services.Session, []string) {
+	byName := map[string]*services.Session{}
+	for _, session := range running {
+		byName[session.NodeName] = session
+	}
+	merged := []*services.Session{}
+	changes := []string{}
+	for _, session := range fresh {
+		old, ok := byName[session.NodeName]
+		delete(byName, session.NodeName)
+		if !ok {
+			merged = append(merged, session)
+			changes = append(changes, fmt.Sprintf("added %s (priority %d)", session.NodeName, session.Priority))
+			continue
+		}
+		changed := sessionChanges(old, session)
+		if len(changed) == 0 {
+			merged = append(merged, old)
+			continue
+		}
+		session.NotSynced = old.NotSynced
+		merged = append(merged, session)
+		changes = append(changes, fmt.Sprintf("updated %s: %s", session.NodeName, strings.Join(changed, ", ")))
+	}
+	for nodeName := range byName {
+		changes = append(changes, fmt.Sprintf("removed %s", nodeName))
+	}
+	return merged, changes
+}
+
+// sessionChanges describes what changed in the stored fields of a session; keys are not printed.
+// Keep it in line with the fields the session store watches (services/sessions.go watchedSession)
+func sessionChanges(old *services.Session, fresh *services.Session) []string {
+	changed := []string{}
+	if old.GroupName != fresh.GroupName {
+		changed = append(changed, fmt.Sprintf("group %s -> %s", old.GroupName, fresh.GroupName))
+	}
+	if old.Priority != fresh.Priority {
+		changed = append(changed, fmt.Sprintf("priority %d -> %d", old.Priority, fresh.Priority))
+	}
+	if old.Collator != fresh.Collator {
+		changed = append(changed, fmt.Sprintf("collator %s -> %s", old.Collator, fresh.Collator))
+	}
+	if old.Proxy != fresh.Proxy {
+		changed = append(changed, fmt.Sprintf("proxy %s -> %s", old.Proxy, fresh.Proxy))
+	}
+	if old.AgentAddress != fresh.AgentAddress {
+		changed = append(changed, fmt.Sprintf("agent %s -> %s", old.AgentAddress, fresh.AgentAddress))
+	}
+	if old.Session != fresh.Session {
+		changed = append(changed, "session key")
+	}
+	if old.VrfKey != fresh.VrfKey {
+		changed = append(changed, "VRF key")
+	}
+	if old.Transactions != fresh.Transactions {
+		changed = append(changed, "presigned transactions")
+	}
+	return changed
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"movrfailover/services"
+	"reflect"
+	"testing"
+)
+
+func TestSessionChanges(t *testing.T) {
+	old := &services.Session{NodeName: "node-a", GroupName: "moonriver", Priority: 1, Session: "0x11"}
+	fresh := *old
+	fresh.Runtime = &services.NodeRuntime{}
+	fresh.Active = true
+	if changed := sessionChanges(old, &fresh); len(changed) != 0 {
+		t.Errorf("runtime state reported as changed: %v", changed)
+	}
+	fresh.GroupName = "moonbase"
+	fresh.Priority = 2
+	fresh.Session = "0x22"
+	expected := []string{"group moonriver -> moonbase", "priority 1 -> 2", "session key"}
+	if changed := sessionChanges(old, &fresh); !reflect.DeepEqual(changed, expected) {
+		t.Errorf("changed %v, expected %v", changed, expected)
+	}
+}
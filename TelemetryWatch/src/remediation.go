@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+	"sync"
+	"time"
+)
+
+var remediatedAt = map[string]int64{} // nodeName -> last remediation
+var remediating = map[string]bool{}   // nodeName -> remediation running
+var rmMX sync.Mutex                   // mx for remediatedAt and remediating
+
+/**
+remediateActive runs the group's ActiveActions on the lagging active nodes and gives them ActiveWaitInSeconds
+to catch up. It returns the nodes that are still lagging, which then fail over as before.
+Every attempt is journaled as a remediation workflow
+**/
+func remediateActive(job *failoverJob, onAlert []*services.Session, lagOf map[string]string) []*services.Session {
+	policy := services.RemediationFor(job.GroupName)
+	if policy == nil || len(policy.ActiveActions) == 0 {
+		return onAlert
+	}
+	stillLagging := []*services.Session{}
+	for _, session := range onAlert {
+		if !startRemediation(session, policy) {
+			stillLagging = append(stillLagging, session) // remediated recently, did not help
+			continue
+		}
+		wait := time.Duration(policy.ActiveWaitInSeconds) * time.Second
+		err := remediate(job, session, policy.ActiveActions, wait, lagOf[session.NodeName], false)
+		endRemediation(session)
+		if err != nil {
+			stillLagging = append(stillLagging, session)
+			continue
+		}
+		message := fmt.Sprintf(`Remediated %s without failover`, session.NodeName)
+		fmt.Printf("%s\n", message)
+		notifyCollator(job.Collator, message)
+	}
+	return stillLagging
+}
+
+// remediateBackup runs the group's BackupActions on a lagging backup in the background
+func remediateBackup(job *failoverJob, session *services.Session, reason string) {
+	policy := services.RemediationFor(job.GroupName)
+	if policy == nil || len(policy.BackupActions) == 0 || isDraining(session) || isFenced(session) {
+		return
+	}
+	if !startRemediation(session, policy) {
+		return
+	}
+
+	go func() {
+		defer endRemediation(session)
+		wait := time.Duration(policy.CooldownInSeconds) * time.Second
+		remediate(job, session, policy.BackupActions, wait, reason, true)
+	}()
+}
+
+// startRemediation claims a node for remediation unless it is being remediated or was within the cooldown
+func startRemediation(session *services.Session, policy *services.RemediationPolicy) bool {
+	now := time.Now().Unix()
+	rmMX.Lock()
+	defer rmMX.Unlock()
+	if remediating[session.NodeName] || now-remediatedAt[session.NodeName] < int64(policy.CooldownInSeconds) {
+		return false
+	}
+	remediating[session.NodeName] = true
+	remediatedAt[session.NodeName] = now
+	return true
+}
+
+func endRemediation(session *services.Session) {
+	rmMX.Lock()
+	remediating[session.NodeName] = false
+	rmMX.Unlock()
+}
+
+// remediate runs remediation actions on a node and waits for it to catch up, as one journaled workflow
+func remediate(job *failoverJob, session *services.Session, actions []services.ActionConfig, wait time.Duration, reason string, quiet bool) error {
+	w := newWorkflow("remediation", job.Collator, job.GroupName)
+	w.Quiet = quiet
+	for i := range actions {
+		config := actions[i]
+		w.add(&workflowStep{
+			Name: config.Type + " " + config.Command,
+			Node: session.NodeName,
+			Run: func() error {
+				action, err := newAction(config)
+				if err != nil {
+					return err
+				}
+				return action.Run(session)
+			},
+		})
+	}
+	w.add(&workflowStep{
+		Name: "wait caught up",
+		Node: session.NodeName,
+		Run: func() error {
+			return waitCaughtUp(job, session, wait)
+		},
+	})
+	fmt.Printf("Remediating %s: %s\n", session.NodeName, reason)
+	err := w.run()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	return err
+}
+
+// waitCaughtUp waits until a node is within the reassociation thresholds again
+func waitCaughtUp(job *failoverJob, session *services.Session, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Duration(services.Config().BLOCK_CHECK_PERIOD_IN_SECONDS) * time.Second)
+		if !laggingNow(session, job.sessions()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s did not catch up within %v of remediation", session.NodeName, timeout)
+}
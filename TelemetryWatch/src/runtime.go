@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signer signs a reassociation in-process; used when the presigned inventory cannot be used
+type Signer interface {
+	HasKey(proxy string) bool
+	SignReassociation(sessAlert *services.Session, sessCandidate *services.Session, proxy string, nonce int, collator string) (string, error)
+}
+
+var signer Signer // nil unless an in-process signer is configured
+
+var runtimeVersions = map[string]*services.RuntimeVersion{} // groupName -> last runtime version seen on chain
+var staleInventory = map[string]string{}                    // nodeName -> reason its presigned txs are unusable
+var rtMX sync.RWMutex                                       // mx for runtimeVersions and staleInventory
+
+/**
+Presigned transactions commit to the specVersion and transactionVersion of the runtime they were signed against.
+After a runtime upgrade the chain rejects all of them, so we follow the runtime version and flag every session
+whose inventory no longer matches, long before we need it in a failover
+**/
+func watchRuntime() {
+	for {
+		for groupName, sessions := range currentSessionGroups() {
+			version, err := services.GetRuntimeVersion(services.RPCEndpoint(groupName))
+			if err != nil {
+				fmt.Printf("%s: %v\n", groupName, err)
+				continue
+			}
+			checkInventory(groupName, version, sessions)
+		}
+		time.Sleep(time.Duration(services.Config().RUNTIME_CHECK_PERIOD_IN_SECONDS) * time.Second)
+	}
+}
+
+// loadRuntime reads the runtime version of a group once, for commands that do not run watchRuntime
+func loadRuntime(groupName string, sessions []*services.Session) error {
+	version, err := services.GetRuntimeVersion(services.RPCEndpoint(groupName))
+	if err != nil {
+		return err
+	}
+	checkInventory(groupName, version, sessions)
+	return nil
+}
+
+func checkInventory(groupName string, version *services.RuntimeVersion, sessions []*services.Session) {
+	windows, err := services.Txs().Windows("", "")
+	if err != nil {
+		fmt.Printf("Cannot check presigned txs of %s: %v\n", groupName, err)
+		return
+	}
+	stale := map[string]string{}
+	for _, window := range windows {
+		if _, ok := stale[window.From]; ok {
+			continue
+		}
+		if err := txsMatchRuntime(window.SpecVersion, window.TransactionVersion, version); err != nil {
+			stale[window.From] = fmt.Sprintf("txs to %s %v", window.To, err)
+		}
+	}
+
+	rtMX.Lock()
+	previous := runtimeVersions[groupName]
+	runtimeVersions[groupName] = version
+	newlyStale := []string{}
+	for _, session := range sessions {
+		reason, isStale := stale[session.NodeName]
+		if _, wasStale := staleInventory[session.NodeName]; isStale && !wasStale {
+			newlyStale = append(newlyStale, fmt.Sprintf("%s (%s)", session.NodeName, reason))
+		}
+		if isStale {
+			staleInventory[session.NodeName] = reason
+		} else {
+			delete(staleInventory, session.NodeName)
+		}
+	}
+	rtMX.Unlock()
+
+	if previous != nil && (previous.SpecVersion != version.SpecVersion || previous.TransactionVersion != version.TransactionVersion) {
+		message := fmt.Sprintf(`Runtime of %s upgraded from spec %d/tx %d to spec %d/tx %d`, groupName,
+			previous.SpecVersion, previous.TransactionVersion, version.SpecVersion, version.TransactionVersion)
+		fmt.Printf("%s\n", message)
+		notifyMe(message)
+	}
+	if len(newlyStale) > 0 {
+		sort.Strings(newlyStale)
+		fallback := "failover is NOT possible until OfflineTxMaker is run again"
+		if services.Config().SIGNER_FALLBACK && signer != nil {
+			fallback = "failover will sign in-process until OfflineTxMaker is run again"
+		}
+		message := fmt.Sprintf(`URGENT: presigned transactions unusable for %s; %s`, strings.Join(newlyStale, ", "), fallback)
+		fmt.Printf("%s\n", message)
+		notifyMe(message)
+	}
+}
+
+// txsMatchRuntime returns an error if the txs were signed against a different runtime than version
+// Inventories made before OfflineTxMaker recorded versions cannot be checked and are assumed valid
+func txsMatchRuntime(specVersion int, transactionVersion int, version *services.RuntimeVersion) error {
+	if version == nil || specVersion == 0 {
+		return nil
+	}
+	if specVersion != version.SpecVersion || transactionVersion != version.TransactionVersion {
+		return fmt.Errorf("signed for spec %d/tx %d but chain is at spec %d/tx %d",
+			specVersion, transactionVersion, version.SpecVersion, version.TransactionVersion)
+	}
+	return nil
+}
+
+// currentRuntime returns the last runtime version seen for a group, nil if not known yet
+func currentRuntime(groupName string) *services.RuntimeVersion {
+	rtMX.RLock()
+	defer rtMX.RUnlock()
+	return runtimeVersions[groupName]
+}
@@ -0,0 +1,38 @@
+package services
+
+// ActionConfig describes a pluggable action run against a node, e.g. a step of an upgrade plan.
+// Type selects the implementation; the other fields are used by the types that need them
+type ActionConfig struct {
+	Type             string            `json:"type"`    // "shell", "ssh", "agent", "http" or "webhook"
+	Command          string            `json:"command"` // shell: run with sh -c; ssh: run on Host; agent: restart, stop, fence
+	Host             string            `json:"host"`    // ssh
+	User             string            `json:"user"`    // ssh
+	URL              string            `json:"url"`     // http, webhook
+	Method           string            `json:"method"`  // http, POST if empty
+	Headers          map[string]string `json:"headers"` // http
+	Body             string            `json:"body"`    // http; webhook: event name
+	TimeoutInSeconds int               `json:"timeoutInSeconds"`
+}
+
+/**
+RemediationPolicy says how the watcher tries to fix a lagging node of a group before (or instead of) a failover.
+The active node gets ActiveActions and ActiveWaitInSeconds to recover before it loses the association;
+lagging backups get BackupActions in the background. A node is remediated at most once per CooldownInSeconds
+**/
+type RemediationPolicy struct {
+	ActiveActions       []ActionConfig `json:"activeActions"`
+	ActiveWaitInSeconds int            `json:"activeWaitInSeconds"`
+	BackupActions       []ActionConfig `json:"backupActions"`
+	CooldownInSeconds   int            `json:"cooldownInSeconds"`
+}
+
+// RemediationFor returns the remediation policy of a group, the "*" policy if it has none, or nil
+func RemediationFor(groupName string) *RemediationPolicy {
+	if policy, ok := Config().REMEDIATION[groupName]; ok {
+		return &policy
+	}
+	if policy, ok := Config().REMEDIATION["*"]; ok {
+		return &policy
+	}
+	return nil
+}
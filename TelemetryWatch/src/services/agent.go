@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AgentHealth is the local view of a node reported by its agent (NodeAgent)
+type AgentHealth struct {
+	Healthy  bool     `json:"healthy"`
+	Problems []string `json:"problems"`
+	RPC      struct {
+		OK        bool   `json:"ok"`
+		Best      int    `json:"best"`
+		Peers     int    `json:"peers"`
+		IsSyncing bool   `json:"isSyncing"`
+		Error     string `json:"error"`
+	} `json:"rpc"`
+	Disk struct {
+		Path        string  `json:"path"`
+		TotalBytes  uint64  `json:"totalBytes"`
+		FreeBytes   uint64  `json:"freeBytes"`
+		FreePercent float64 `json:"freePercent"`
+		Error       string  `json:"error"`
+	} `json:"disk"`
+	Memory struct {
+		TotalBytes     uint64 `json:"totalBytes"`
+		AvailableBytes uint64 `json:"availableBytes"`
+		Error          string `json:"error"`
+	} `json:"memory"`
+	Process struct {
+		Unit   string `json:"unit"`
+		Active bool   `json:"active"`
+		State  string `json:"state"`
+	} `json:"process"`
+	CheckedAt int64 `json:"checkedAt"`
+}
+
+// Key types of the keys checked with HasKeys
+const (
+	NimbusKeyType = "nmbs"
+	VrfKeyType    = "rand"
+)
+
+// AgentClient talks to the agent of one node; requests are signed with AGENT_TOKEN
+type AgentClient struct {
+	Address string // e.g. http://10.0.0.5:9955
+	token   string
+	client  *http.Client
+}
+
+// Agent returns a client for the agent of a session, or an error if the session has no agent
+func Agent(session *Session) (*AgentClient, error) {
+	if session.AgentAddress == "" {
+		return nil, fmt.Errorf("%s has no agent address", session.NodeName)
+	}
+	return &AgentClient{
+		Address: strings.TrimSuffix(session.AgentAddress, "/"),
+		token:   Config().AGENT_TOKEN,
+		client:  &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// agentSignature must match the agent: hex(HMAC-SHA256(token, method \n path?query \n timestamp \n nonce \n body))
+func agentSignature(token string, method string, uri string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *AgentClient) do(method string, uri string, out interface{}) ([]byte, error) {
+	req, err := http.NewRequest(method, a.Address+uri, bytes.NewReader(nil))
+	if err != nil {
+		return nil, err
+	}
+	// the agent accepts every nonce once, so a captured request cannot be replayed
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Agent-Timestamp", timestamp)
+	req.Header.Set("X-Agent-Nonce", hex.EncodeToString(nonce))
+	req.Header.Set("X-Agent-Signature", agentSignature(a.token, method, uri, timestamp, hex.EncodeToString(nonce), nil))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent %s %s: %s %s", a.Address, uri, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if out != nil {
+		return body, json.Unmarshal(body, out)
+	}
+	return body, nil
+}
+
+func (a *AgentClient) Health() (*AgentHealth, error) {
+	health := &AgentHealth{}
+	_, err := a.do("GET", "/health", health)
+	return health, err
+}
+
+// Control runs restart, stop, fence (stop and mask the unit) or unfence on the node
+func (a *AgentClient) Control(verb string) error {
+	switch verb {
+	case "restart", "stop", "fence", "unfence":
+	default:
+		return fmt.Errorf("unknown agent action %s", verb)
+	}
+	_, err := a.do("POST", "/"+verb, nil)
+	return err
+}
+
+// HasKeys reports whether the node's keystore holds the nimbus session key and, if given, the VRF key
+func (a *AgentClient) HasKeys(session string, vrfKey string) (bool, error) {
+	query := url.Values{}
+	query.Set(NimbusKeyType, session)
+	if vrfKey != "" {
+		query.Set(VrfKeyType, vrfKey)
+	}
+	keys := map[string]bool{}
+	if _, err := a.do("GET", "/keys?"+query.Encode(), &keys); err != nil {
+		return false, err
+	}
+	for _, has := range keys {
+		if !has {
+			return false, nil
+		}
+	}
+	return len(keys) > 0, nil
+}
+
+// Logs returns the last lines of the node's journal
+func (a *AgentClient) Logs(lines int) (string, error) {
+	body, err := a.do("GET", fmt.Sprintf("/logs?lines=%d", lines), nil)
+	return string(body), err
+}
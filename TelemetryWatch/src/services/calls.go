@@ -0,0 +1,126 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// authorMapping call indices that came with the nimbus + VRF keys runtimes
+const (
+	RemoveKeysIndex = 3 // authorMapping.removeKeys()
+	SetKeysIndex    = 4 // authorMapping.setKeys(keys)
+)
+
+/**
+CallBuilder encodes and checks the authorMapping call that hands block authoring from one session to another.
+Moonbeam changed this call over time (updateAssociation(old, new), then setKeys(nimbus ++ vrf)),
+so the in-process signer picks the builder by the runtime version of the group the sessions belong to.
+OfflineTxMaker picks the call from the runtime metadata, so a presigned tx is checked with the builder
+of the call it carries (see CallBuilderOf)
+**/
+type CallBuilder interface {
+	Name() string
+	// Reassociate encodes the call that moves authoring from sessFrom to sessTo
+	Reassociate(sessFrom *Session, sessTo *Session) (Call, error)
+	// Check returns an error unless call moves authoring from sessFrom to sessTo
+	Check(call Call, sessFrom *Session, sessTo *Session) error
+}
+
+// CallBuilderFor returns the call builder for a runtime spec version
+func CallBuilderFor(specVersion int) CallBuilder {
+	if Config().SET_KEYS_SPEC_VERSION > 0 && specVersion >= Config().SET_KEYS_SPEC_VERSION {
+		return setKeysBuilder{}
+	}
+	return updateAssociationBuilder{}
+}
+
+// CallBuilderOf returns the call builder that encodes call, to check a tx whatever runtime it was signed against
+func CallBuilderOf(call Call) (CallBuilder, error) {
+	if call.Pallet == AuthorMappingPalletIndex && call.Method == UpdateAssociationIndex {
+		return updateAssociationBuilder{}, nil
+	}
+	if call.Pallet == AuthorMappingPalletIndex && call.Method == SetKeysIndex {
+		return setKeysBuilder{}, nil
+	}
+	return nil, fmt.Errorf("call %d.%d is not an authorMapping reassociation", call.Pallet, call.Method)
+}
+
+type updateAssociationBuilder struct{}
+
+func (updateAssociationBuilder) Name() string {
+	return "authorMapping.updateAssociation"
+}
+
+func (updateAssociationBuilder) Reassociate(sessFrom *Session, sessTo *Session) (Call, error) {
+	oldKey, err := sessionKey(sessFrom.Session)
+	if err != nil {
+		return Call{}, fmt.Errorf("session of %s: %v", sessFrom.NodeName, err)
+	}
+	newKey, err := sessionKey(sessTo.Session)
+	if err != nil {
+		return Call{}, fmt.Errorf("session of %s: %v", sessTo.NodeName, err)
+	}
+	return Call{Pallet: AuthorMappingPalletIndex, Method: UpdateAssociationIndex, Args: append(oldKey, newKey...)}, nil
+}
+
+func (updateAssociationBuilder) Check(call Call, sessFrom *Session, sessTo *Session) error {
+	association, err := call.DecodeUpdateAssociation()
+	if err != nil {
+		return err
+	}
+	if !SameHex(association.Old, sessFrom.Session) {
+		return fmt.Errorf("moves association from %s instead of %s (%s)", association.Old, sessFrom.Session, sessFrom.NodeName)
+	}
+	if !SameHex(association.New, sessTo.Session) {
+		return fmt.Errorf("moves association to %s instead of %s (%s)", association.New, sessTo.Session, sessTo.NodeName)
+	}
+	return nil
+}
+
+// setKeys replaces whatever keys the collator account has, so sessFrom is not part of the call
+type setKeysBuilder struct{}
+
+func (setKeysBuilder) Name() string {
+	return "authorMapping.setKeys"
+}
+
+func (setKeysBuilder) Reassociate(sessFrom *Session, sessTo *Session) (Call, error) {
+	nimbusKey, err := sessionKey(sessTo.Session)
+	if err != nil {
+		return Call{}, fmt.Errorf("session of %s: %v", sessTo.NodeName, err)
+	}
+	if sessTo.VrfKey == "" {
+		return Call{}, fmt.Errorf("%s has no vrfKey, required by setKeys", sessTo.NodeName)
+	}
+	vrfKey, err := sessionKey(sessTo.VrfKey)
+	if err != nil {
+		return Call{}, fmt.Errorf("vrfKey of %s: %v", sessTo.NodeName, err)
+	}
+	keys := append(nimbusKey, vrfKey...)
+	return Call{Pallet: AuthorMappingPalletIndex, Method: SetKeysIndex, Args: append(encodeCompact(uint64(len(keys))), keys...)}, nil
+}
+
+func (b setKeysBuilder) Check(call Call, sessFrom *Session, sessTo *Session) error {
+	if call.Pallet != AuthorMappingPalletIndex || call.Method != SetKeysIndex {
+		return fmt.Errorf("call %d.%d is not %s", call.Pallet, call.Method, b.Name())
+	}
+	expected, err := b.Reassociate(sessFrom, sessTo)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(call.Args, expected.Args) {
+		return fmt.Errorf("sets keys %s instead of the keys of %s", EncodeHex(call.Args), sessTo.NodeName)
+	}
+	return nil
+}
+
+func sessionKey(key string) ([]byte, error) {
+	raw, err := DecodeHex(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, expected 32", len(raw))
+	}
+	return raw, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+var sessionFrom = &Session{NodeName: "node-a", Session: "0x" + strings.Repeat("11", 32)}
+var sessionTo = &Session{NodeName: "node-b", Session: "0x" + strings.Repeat("22", 32), VrfKey: "0x" + strings.Repeat("33", 32)}
+
+const proxiedAccount = "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+func TestReassociateEncoding(t *testing.T) {
+	cases := []struct {
+		builder CallBuilder
+		call    string
+	}{
+		// authorMapping (23) . updateAssociation (1), old key, new key
+		{updateAssociationBuilder{}, "0x1701" + strings.Repeat("11", 32) + strings.Repeat("22", 32)},
+		// authorMapping (23) . setKeys (4), compact length 64, nimbus key ++ vrf key
+		{setKeysBuilder{}, "0x1704" + "0101" + strings.Repeat("22", 32) + strings.Repeat("33", 32)},
+	}
+	for _, c := range cases {
+		call, err := c.builder.Reassociate(sessionFrom, sessionTo)
+		if err != nil {
+			t.Fatalf("%s: %v", c.builder.Name(), err)
+		}
+		if got := EncodeHex(call.Encode()); got != c.call {
+			t.Errorf("%s encodes %s, expected %s", c.builder.Name(), got, c.call)
+		}
+		if err := c.builder.Check(call, sessionFrom, sessionTo); err != nil {
+			t.Errorf("%s rejects its own call: %v", c.builder.Name(), err)
+		}
+		if err := c.builder.Check(call, sessionTo, sessionFrom); err == nil {
+			t.Errorf("%s accepts the reverse reassociation", c.builder.Name())
+		}
+	}
+}
+
+func TestSetKeysRequiresVrfKey(t *testing.T) {
+	noVrf := &Session{NodeName: "node-c", Session: "0x" + strings.Repeat("22", 32)}
+	if _, err := (setKeysBuilder{}).Reassociate(sessionFrom, noVrf); err == nil {
+		t.Error("setKeys encoded a call without a vrfKey")
+	}
+}
+
+func TestProxyCallFor(t *testing.T) {
+	call, err := (setKeysBuilder{}).Reassociate(sessionFrom, sessionTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := ProxyCallFor(proxiedAccount, ProxyTypeAuthorMapping, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// proxy (31) . proxy (0), real, Some(AuthorMapping), call
+	expected := "0x1f00" + strings.Repeat("aa", 20) + "0106" + strings.TrimPrefix(EncodeHex(call.Encode()), "0x")
+	if got := EncodeHex(proxy.Encode()); got != expected {
+		t.Errorf("proxy call is %s, expected %s", got, expected)
+	}
+	decoded, err := proxy.DecodeProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Real != proxiedAccount || decoded.ForceProxyType != ProxyTypeAuthorMapping {
+		t.Errorf("decoded real %s type %d", decoded.Real, decoded.ForceProxyType)
+	}
+	if EncodeHex(decoded.Call.Encode()) != EncodeHex(call.Encode()) {
+		t.Errorf("decoded inner call %s", EncodeHex(decoded.Call.Encode()))
+	}
+}
+
+func TestSigningPayload(t *testing.T) {
+	call, err := (setKeysBuilder{}).Reassociate(sessionFrom, sessionTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := ProxyCallFor(proxiedAccount, ProxyTypeAuthorMapping, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := &RuntimeVersion{SpecVersion: 1201, TransactionVersion: 2}
+	genesis := "0x" + strings.Repeat("44", 32)
+	payload, err := SigningPayload(proxy, 5, version, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "0x1f00" + strings.Repeat("aa", 20) + "0106" +
+		"17040101" + strings.Repeat("22", 32) + strings.Repeat("33", 32) +
+		"00" + // immortal era
+		"14" + // compact nonce 5
+		"00" + // compact tip 0
+		"b1040000" + // spec version 1201
+		"02000000" + // tx version 2
+		strings.Repeat("44", 32) + // genesis hash
+		strings.Repeat("44", 32) // checkpoint, the genesis hash for immortal txs
+	if got := EncodeHex(payload); got != expected {
+		t.Errorf("payload is\n%s\nexpected\n%s", got, expected)
+	}
+
+	// payloads over 256 bytes are signed by their blake2b-256 hash
+	long := Call{Pallet: AuthorMappingPalletIndex, Method: SetKeysIndex, Args: make([]byte, 200)}
+	payload, err = SigningPayload(long, 5, version, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 32 {
+		t.Errorf("payload of a long call is %d bytes instead of its 32 byte hash", len(payload))
+	}
+
+	if _, err := SigningPayload(proxy, 5, version, "0x4444"); err == nil {
+		t.Error("accepted a short genesis hash")
+	}
+}
+
+func TestSignedExtrinsicRoundTrip(t *testing.T) {
+	key, err := ParseEthereumKey(eip155Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, err := (setKeysBuilder{}).Reassociate(sessionFrom, sessionTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := ProxyCallFor(proxiedAccount, ProxyTypeAuthorMapping, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := SigningPayload(proxy, 70, &RuntimeVersion{SpecVersion: 1201, TransactionVersion: 2}, "0x"+strings.Repeat("44", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := key.Sign(payload)
+	tx, err := EncodeSignedExtrinsic(key.Address, signature, 70, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extrinsic, err := DecodeExtrinsic(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SameHex(extrinsic.Signer, key.Address) || extrinsic.Nonce != 70 || extrinsic.Tip != 0 {
+		t.Errorf("decoded signer %s nonce %d tip %d", extrinsic.Signer, extrinsic.Nonce, extrinsic.Tip)
+	}
+	if EncodeHex(extrinsic.Signature) != EncodeHex(signature) || EncodeHex(extrinsic.Era) != "0x00" {
+		t.Errorf("decoded signature %s era %s", EncodeHex(extrinsic.Signature), EncodeHex(extrinsic.Era))
+	}
+	if EncodeHex(extrinsic.Call.Encode()) != EncodeHex(proxy.Encode()) {
+		t.Errorf("decoded call %s", EncodeHex(extrinsic.Call.Encode()))
+	}
+}
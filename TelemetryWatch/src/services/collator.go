@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+)
+
+// Collator is one collator account protected by the watcher and the proxies that reassociate on its behalf
+type Collator struct {
+	Name    string   `json:"name"`    // label used in alerts and reports
+	Account string   `json:"account"` // collator account, the `real` of the proxy call
+	Proxies []string `json:"proxies"` // AuthorMapping proxies of the account, most preferred first
+}
+
+// DefaultCollator is used for sessions that do not name a collator, as in single collator setups
+const DefaultCollator = "default"
+
+// CollatorOf resolves the collator a session belongs to from the COLLATORS config.
+// Sessions without a collator belong to the only configured collator, or to an implicit
+// default collator whose account is learned from the chain and whose proxy is the session's
+func CollatorOf(session *Session) (*Collator, error) {
+	collators := Config().COLLATORS
+	if session.Collator == "" {
+		if len(collators) == 1 {
+			return &collators[0], nil
+		}
+		if len(collators) == 0 {
+			return &Collator{Name: DefaultCollator}, nil
+		}
+		return nil, fmt.Errorf("%s does not name a collator and %d are configured", session.NodeName, len(collators))
+	}
+	for i := range collators {
+		if collators[i].Name == session.Collator {
+			return &collators[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s belongs to unknown collator %s", session.NodeName, session.Collator)
+}
+
+// ProxiesFor returns the ranked proxies that may reassociate a session of the collator
+func (c *Collator) ProxiesFor(session *Session) []string {
+	if len(c.Proxies) == 0 {
+		return []string{session.Proxy}
+	}
+	return c.Proxies
+}
+
+// HasProxy reports whether proxy is one of the collator's proxies
+func (c *Collator) HasProxy(proxy string) bool {
+	for _, p := range c.Proxies {
+		if SameHex(p, proxy) {
+			return true
+		}
+	}
+	return false
+}
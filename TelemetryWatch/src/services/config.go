@@ -18,6 +18,57 @@ type Configuration struct {
 	BLOCK_CHECK_PERIOD_IN_SECONDS   int
 	REST_SESSION                    string
 	REST_WHOS_SESSION               string
+	RPC_ENDPOINT                    string            // http endpoint of a node used for direct JSON-RPC queries
+	RPC_ENDPOINTS                   map[string]string // groupName -> endpoint, for groups not on RPC_ENDPOINT's chain
+	RUNTIME_CHECK_PERIOD_IN_SECONDS int
+	SIGNER_FALLBACK                 bool // sign in-process when the presigned inventory is unusable
+	SKIP_PRESIGNED_TX_VALIDATION    bool // opt-out: submit presigned txs without decrypting and checking them
+	KMS_KEY_ARN                     string
+	SET_KEYS_SPEC_VERSION           int               // first runtime using authorMapping.setKeys; 0 to always use updateAssociation
+	SIGNER_KEYS                     map[string]string // proxy address -> private key wrapped like the presigned txs
+	PROXY_MIN_BALANCE               string            // in wei; proxies with less are skipped for reassociations
+	JOURNAL_BACKEND                 string            // "file" (default) or "dynamodb"
+	JOURNAL_FILE                    string            // append-only log of reassociation attempts
+	JOURNAL_TABLE                   string            // DynamoDB table (hash key collator, range key sk)
+	PENDING_TX_TIMEOUT_IN_SECONDS   int               // how long a submitted reassociation counts as in flight
+	RECENT_BLOCKS_TO_CHECK          int               // blocks searched for an already included reassociation
+	RELOAD_PERIOD_IN_SECONDS        int               // how often sessions, maintenance windows and fences are re-read
+	STATE_SAVE_PERIOD_IN_SECONDS    int               // how often the runtime state of the nodes is saved to the session store
+	FIRST_BLOCKS_WAIT_IN_SECONDS    int               // longest wait at startup for every node to report a block
+	DRAIN_HANDOVER_LEAD_IN_SECONDS  int               // hand the association over this long before a window starts
+	FENCE_POLICY                    string            // "none" (default), "before" or "after" reassociation
+	FENCE_ACTIONS                   []ActionConfig    // run in order to fence a node that lost the association
+	AGENT_TOKEN                     string            // shared secret of the node agents
+	COLLATORS                       []Collator        // collators protected by this watcher; may be empty for one collator
+
+	REMEDIATION     map[string]RemediationPolicy // groupName ("*" for any) -> how lagging nodes are fixed
+	AWS_REGION      string                       // eu-central-1 if empty
+	SESSION_BACKEND string                       // "dynamodb" (default), "file" or "memory"
+	SESSION_TABLE   string                       // DynamoDB table of the sessions, keyed by nodeName
+	SESSION_FILE    string                       // JSON or YAML (by extension) list of sessions for the file backend
+	TX_BACKEND      string                       // "sessions" (default, the Transactions blob), "dynamodb", "file" or "memory"
+	TX_TABLE        string                       // DynamoDB table of the presigned txs (hash key pair, range key nonce)
+	TX_FILE         string                       // JSON file of the presigned txs for the file backend
+
+	LEASE_BACKEND        string // "" for a single watcher, "dynamodb", "file" or "memory" for a leader among several
+	LEASE_TABLE          string // DynamoDB table of the leases, keyed by name
+	LEASE_FILE           string // lease file for the file backend, locked with flock (use a shared filesystem)
+	LEASE_TTL_IN_SECONDS int    // the leader renews its lease every third of it; at least 6
+	WATCHER_ID           string // name of this watcher in the lease; the hostname if empty
+	QUORUM               int    // watchers that must find the active node unhealthy before a reassociation
+	VOTE_BACKEND         string // "" to decide alone, "dynamodb", "file" or "memory" to vote with other watchers
+	VOTE_TABLE           string // DynamoDB table of the verdicts (hash key nodeName, range key watcher)
+	VOTE_FILE            string // verdicts file for the file backend, locked with flock (use a shared filesystem)
+	VOTE_TTL_IN_SECONDS  int    // verdicts older than this do not count
+
+	SECRETS_TTL_IN_SECONDS int    // cached keys are fetched again after this (300 if 0); kept if fetching fails
+	SECRETS_PROVIDER       string // "aws" (default, Secrets Manager), "env", "file", "vault" or "memory"
+	SECRET_NAME            string // Secrets Manager secret of the keys
+	SECRETS_FILE           string // JSON file of the keys for the file provider; must not be readable by others
+	VAULT_ADDR             string // e.g. https://vault:8200
+	VAULT_MOUNT            string // KV version 2 mount, "secret" if empty
+	VAULT_PATH             string // path of the keys in the mount
+	VAULT_TOKEN_FILE       string // file holding the Vault token; the VAULT_TOKEN environment variable if empty
 }
 
 var onceConf sync.Once
@@ -42,3 +93,11 @@ func loadConfig() {
 		panic(err)
 	}
 }
+
+// AWSRegion is the region of all AWS services used by the watcher
+func AWSRegion() string {
+	if Config().AWS_REGION == "" {
+		return "eu-central-1"
+	}
+	return Config().AWS_REGION
+}
@@ -0,0 +1,7 @@
+package services
+
+// useConfig replaces the configuration for a test instead of reading ./<ENVIR>_config.json
+func useConfig(c Configuration) {
+	onceConf.Do(func() {})
+	configuration = c
+}
@@ -1,35 +1,178 @@
 package services
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/chrisxue815/realworld-aws-lambda-dynamodb-go/util"
 )
 
 var onceDB sync.Once
 var svcDB *dynamodb.DynamoDB
 var databaseName string
-var tableName = "YOUR-TABLE-NAME"
 
 type AWSObject = map[string]*dynamodb.AttributeValue
 
 type Session struct {
-	NodeName     string `json:"nodeName"`     // key
-	GroupName    string `json:"groupName"`    // backup group
-	Session      string `json:"session"`      // encrypted session key
-	Priority     int    `json:"priority"`     // higher priority gets activated first
-	Transactions string `json:"transactions"` // encrypted presigned raw reassociation transactions
-	Proxy        string `json:"proxy"`        // the proxy account address
+	NodeName     string `json:"nodeName"`               // key
+	GroupName    string `json:"groupName"`              // backup group
+	Collator     string `json:"collator,omitempty"`     // name of the collator (in COLLATORS) this node authors for
+	Session      string `json:"session"`                // encrypted session key
+	Priority     int    `json:"priority"`               // higher priority gets activated first
+	Transactions string `json:"transactions"`           // encrypted presigned raw reassociation transactions
+	Proxy        string `json:"proxy"`                  // the proxy account address
+	VrfKey       string `json:"vrfKey,omitempty"`       // VRF key registered next to the session key by authorMapping.setKeys
+	Drain        *Drain `json:"drain,omitempty"`        // planned maintenance window, nil if none
+	Fence        *Fence `json:"fence,omitempty"`        // set while the node is fenced; it is no candidate until unfenced
+	AgentAddress string `json:"agentAddress,omitempty"` // http address of the node agent (NodeAgent), empty if none
+	Schema       int    `json:"schema"`                 // schema version the row was written with (SessionSchema)
+	// descriptive, for operators (`movrfailover sessions`)
+	Endpoints []string `json:"endpoints,omitempty"` // RPC/WS endpoints of the node
+	Tags      []string `json:"tags,omitempty"`      // free-form labels, e.g. region or provider
+	// written by the watcher, restored when it restarts
+	Active  bool         `json:"active,omitempty"` // associated when the watcher last reassociated
+	Runtime *NodeRuntime `json:"runtime,omitempty"`
 	// not stored in DB (local)
-	Stopped   bool // true if removed association automatically
-	NotSynced bool
+	NotSynced bool `json:"-"`
+}
+
+// Drain is a planned maintenance window of a node; times are unix seconds
+type Drain struct {
+	From   int64 `json:"from"`
+	Until  int64 `json:"until"`  // 0 until cleared
+	Return bool  `json:"return"` // reassociate back to the node once the window is over and it is healthy
+}
+
+// Active reports whether the window is open, or opens within lead seconds
+func (d *Drain) Active(now int64, lead int64) bool {
+	return d != nil && now >= d.From-lead && (d.Until == 0 || now < d.Until)
+}
+
+// Over reports whether the window has closed
+func (d *Drain) Over(now int64) bool {
+	return d != nil && d.Until > 0 && now >= d.Until
+}
+
+// NodeRuntime is what the watcher knew about a node when it last saved it; times are unix seconds
+type NodeRuntime struct {
+	State        string         `json:"state"`
+	StateSince   int64          `json:"stateSince"`
+	StateReason  string         `json:"stateReason,omitempty"`
+	Imported     int            `json:"imported"`
+	Finalized    int            `json:"finalized"`
+	NotSynced    bool           `json:"notSynced,omitempty"`
+	RemediatedAt int64          `json:"remediatedAt,omitempty"` // last remediation; its cooldown survives restarts
+	NotifiedAt   map[string]int `json:"notifiedAt,omitempty"`   // alert key -> last alert, for the alert chill period
+	LastFailover *LastFailover  `json:"lastFailover,omitempty"` // last reassociation away from the node
+	SavedAt      int64          `json:"savedAt"`
+}
+
+// LastFailover is the last completed reassociation from a node
+type LastFailover struct {
+	At       int64  `json:"at"`
+	Collator string `json:"collator"`
+	To       string `json:"to"`
+	Nonce    int    `json:"nonce"`
+}
+
+// Fence records that a node was fenced (stopped) so it cannot author next to its replacement
+type Fence struct {
+	At     int64  `json:"at"` // unix seconds
+	Reason string `json:"reason"`
+	Error  string `json:"error"` // last fence action error, empty if all succeeded
+}
+
+// PresignedTxs is the inventory of presigned transactions from one session to another,
+// one transaction per nonce starting at Nonce
+type PresignedTxs struct {
+	TXs                []string `json:"txs"`
+	Nonce              int      `json:"nonce"`
+	SpecVersion        int      `json:"specVersion"`        // runtime the txs were signed against; 0 if unknown
+	TransactionVersion int      `json:"transactionVersion"` // 0 if unknown
+	Proxy              string   `json:"proxy"`              // proxy that signed the txs; empty in older rows
+	CreatedAt          int64    `json:"createdAt"`          // unix seconds; 0 in older rows
+	KeyVersion         int      `json:"keyVersion"`         // version of the keys the txs are encrypted with; 0 in older rows
+}
+
+// Inventory unmarshals the Transactions blob into a map of target nodeName -> presigned txs
+func (s *Session) Inventory() (map[string]PresignedTxs, error) {
+	inventory := map[string]PresignedTxs{}
+	if s.Transactions == "" {
+		return inventory, nil
+	}
+	err := json.Unmarshal([]byte(s.Transactions), &inventory)
+	return inventory, err
+}
+
+// PresignedFor returns the presigned txs to nodeName signed by proxy, nil if there are none.
+// Txs of the session's own proxy are keyed by the target node name (older rows do not record the proxy),
+// txs of backup proxies by "nodeName@proxy"
+func (s *Session) PresignedFor(nodeName string, proxy string) (*PresignedTxs, error) {
+	inventory, err := s.Inventory()
+	if err != nil {
+		return nil, err
+	}
+	for key, txs := range inventory {
+		target, signedBy := s.inventoryKey(key, txs)
+		if target == nodeName && SameHex(signedBy, proxy) {
+			return &txs, nil
+		}
+	}
+	return nil, nil
+}
+
+// inventoryKey resolves the target node and the proxy of an entry of the Transactions blob
+func (s *Session) inventoryKey(key string, txs PresignedTxs) (string, string) {
+	target := key
+	signedBy := txs.Proxy
+	if at := strings.Index(key, "@"); at >= 0 {
+		target = key[:at]
+		if signedBy == "" {
+			signedBy = key[at+1:]
+		}
+	}
+	if signedBy == "" {
+		signedBy = s.Proxy
+	}
+	return target, signedBy
+}
+
+// Windows converts the Transactions blob into the windows of the tx store
+func (s *Session) Windows(collator string) ([]*TxWindow, error) {
+	inventory, err := s.Inventory()
+	if err != nil {
+		return nil, err
+	}
+	windows := []*TxWindow{}
+	for key, txs := range inventory {
+		target, signedBy := s.inventoryKey(key, txs)
+		windows = append(windows, txs.Window(collator, s.NodeName, target, signedBy))
+	}
+	return windows, nil
+}
+
+// Window is the txs as a window of the tx store
+func (p *PresignedTxs) Window(collator string, from string, to string, proxy string) *TxWindow {
+	return &TxWindow{
+		Collator:           collator,
+		From:               from,
+		To:                 to,
+		Proxy:              proxy,
+		Nonce:              p.Nonce,
+		Count:              len(p.TXs),
+		TXs:                p.TXs,
+		SpecVersion:        p.SpecVersion,
+		TransactionVersion: p.TransactionVersion,
+		CreatedAt:          p.CreatedAt,
+		KeyVersion:         p.KeyVersion,
+	}
 }
 
 func initializeDB() {
@@ -37,12 +180,12 @@ func initializeDB() {
 	var err error
 	if ENVIR == "dev" {
 		sess, err = session.NewSession(&aws.Config{
-			Region:      aws.String("eu-central-1"),
+			Region:      aws.String(AWSRegion()),
 			Credentials: credentials.NewSharedCredentials("", "movrfailover"),
 		})
 	} else {
 		sess, err = session.NewSession(&aws.Config{
-			Region: aws.String("eu-central-1"),
+			Region: aws.String(AWSRegion()),
 		})
 	}
 	if err != nil {
@@ -102,25 +245,3 @@ func UpdateBoolValue(tableName string, key string, keyVal string, attribute stri
 	_, err := DynamoDB().UpdateItem(input)
 	return err
 }
-
-func ScanSessions(out *[]*Session) error {
-	params := dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	}
-	items, err := ScanItems(&params, 0, 1000)
-	if err != nil {
-		return err
-	}
-	err = dynamodbattribute.UnmarshalListOfMaps(items, out)
-	return err
-}
-
-func UpdateSessionActive(nodeName string, active bool) error {
-	err := UpdateBoolValue(tableName, "nodeName", nodeName, "active", active)
-	return err
-}
-
-func UpdateSessionStopped(nodeName string, stopped bool) error {
-	err := UpdateBoolValue(tableName, "nodeName", nodeName, "stopped", stopped)
-	return err
-}
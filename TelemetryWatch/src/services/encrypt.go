@@ -4,9 +4,13 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 )
 
 func GenerateKey() (string, error) {
@@ -18,65 +22,183 @@ func GenerateKey() (string, error) {
 	return key, nil
 }
 
-func Encrypt(stringToEncrypt string, keyString string) (encryptedString string) {
+/**
+Ciphertexts are written as a versioned envelope:
 
-	//Since the key is in string, we need to convert decode it to bytes
-	key, _ := hex.DecodeString(keyString)
-	plaintext := []byte(stringToEncrypt)
+	v1.<key id>.<algorithm>.<base64url associated data>.<hex nonce and sealed data>
 
-	//Create a new Cipher Block from the key
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		panic(err.Error())
+The whole header is authenticated with the data, so a ciphertext cannot be moved to another context
+(collator, from/to pair, nonce) or be relabelled with another key. Ciphertexts written before the envelope
+(bare hex, AES-256-GCM, nonce first) still decrypt, but only where no context is expected
+**/
+const envelopeVersion = "v1"
+const envelopeAlgorithm = "A256GCM"
+
+// EnvelopeContext is what a ciphertext is bound to; the zero value binds it to nothing
+type EnvelopeContext struct {
+	Collator string
+	From     string
+	To       string
+	Nonce    int
+}
+
+// AssociatedData is the canonical form of the context, empty for the zero value
+func (c EnvelopeContext) AssociatedData() string {
+	if c == (EnvelopeContext{}) {
+		return ""
 	}
+	return fmt.Sprintf("collator=%s;from=%s;to=%s;nonce=%d", c.Collator, c.From, c.To, c.Nonce)
+}
 
-	//Create a new GCM - https://en.wikipedia.org/wiki/Galois/Counter_Mode
-	//https://golang.org/pkg/crypto/cipher/#NewGCM
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		panic(err.Error())
+// Keyring holds the active encryption keys: the primary one encrypts, all of them decrypt during a rotation
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// NewKeyring checks the keys (key id -> 64 hex chars) and the primary key id
+func NewKeyring(primary string, keys map[string]string) (*Keyring, error) {
+	ring := &Keyring{primary: primary, keys: map[string][]byte{}}
+	for id, keyString := range keys {
+		if id == "" || strings.Contains(id, ".") {
+			return nil, fmt.Errorf("key id %q must be non-empty and without dots", id)
+		}
+		key, err := hex.DecodeString(keyString)
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not hex", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s is %d bytes instead of 32", id, len(key))
+		}
+		ring.keys[id] = key
+	}
+	if _, ok := ring.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
 	}
+	return ring, nil
+}
+
+// KeyID names a key by its fingerprint, for keys handed around without an id
+func KeyID(keyString string) string {
+	hash := sha256.Sum256([]byte(keyString))
+	return hex.EncodeToString(hash[:4])
+}
 
-	//Create a nonce. Nonce should be from GCM
+// Encrypt seals plaintext under the primary key, bound to context
+func (k *Keyring) Encrypt(plaintext string, context EnvelopeContext) (string, error) {
+	aesGCM, err := newGCM(k.keys[k.primary])
+	if err != nil {
+		return "", err
+	}
 	nonce := make([]byte, aesGCM.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err.Error())
+		return "", err
 	}
+	header := envelopeHeader(k.primary, envelopeAlgorithm, context.AssociatedData())
+	sealed := aesGCM.Seal(nonce, nonce, []byte(plaintext), []byte(header))
+	return header + "." + hex.EncodeToString(sealed), nil
+}
 
-	//Encrypt the data using aesGCM.Seal
-	//Since we don't want to save the nonce somewhere else in this case, we add it as a prefix to the encrypted data. The first nonce argument in Seal is the prefix.
-	ciphertext := aesGCM.Seal(nonce, nonce, plaintext, nil)
-	return fmt.Sprintf("%x", ciphertext)
+// Decrypt opens an envelope written under any key of the ring, failing unless it is bound to context
+func (k *Keyring) Decrypt(encrypted string, context EnvelopeContext) (string, error) {
+	if !strings.HasPrefix(encrypted, envelopeVersion+".") {
+		return k.decryptLegacy(encrypted, context)
+	}
+	parts := strings.Split(encrypted, ".")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("malformed envelope: %d parts instead of 5", len(parts))
+	}
+	id, algorithm, encodedData := parts[1], parts[2], parts[3]
+	if algorithm != envelopeAlgorithm {
+		return "", fmt.Errorf("unsupported algorithm %s", algorithm)
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("unknown key %s, active keys are %s", id, strings.Join(k.ids(), ", "))
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encodedData)
+	if err != nil {
+		return "", fmt.Errorf("malformed associated data: %v", err)
+	}
+	if string(data) != context.AssociatedData() {
+		return "", fmt.Errorf("ciphertext is bound to %q, not %q", data, context.AssociatedData())
+	}
+	sealed, err := hex.DecodeString(parts[4])
+	if err != nil {
+		return "", fmt.Errorf("malformed ciphertext: %v", err)
+	}
+	return open(key, sealed, []byte(envelopeHeader(id, algorithm, string(data))))
+}
+
+// decryptLegacy opens a bare hex ciphertext, which carries neither key id nor context
+func (k *Keyring) decryptLegacy(encrypted string, context EnvelopeContext) (string, error) {
+	if context.AssociatedData() != "" {
+		return "", fmt.Errorf("ciphertext is not an envelope and cannot be bound to %q", context.AssociatedData())
+	}
+	sealed, err := hex.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("ciphertext is neither an envelope nor hex")
+	}
+	for _, id := range k.ids() {
+		if plain, err := open(k.keys[id], sealed, nil); err == nil {
+			return plain, nil
+		}
+	}
+	return "", fmt.Errorf("no active key opens the ciphertext")
 }
 
-func Decrypt(encryptedString string, keyString string) (decryptedString string) {
+func (k *Keyring) ids() []string {
+	ids := []string{}
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
 
-	key, _ := hex.DecodeString(keyString)
-	enc, _ := hex.DecodeString(encryptedString)
+func envelopeHeader(id string, algorithm string, data string) string {
+	return strings.Join([]string{envelopeVersion, id, algorithm, base64.RawURLEncoding.EncodeToString([]byte(data))}, ".")
+}
 
-	//Create a new Cipher Block from the key
+func newGCM(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	//Create a new GCM
-	aesGCM, err := cipher.NewGCM(block)
+// open splits the nonce off sealed data and authenticates and decrypts the rest
+func open(key []byte, sealed []byte, additionalData []byte) (string, error) {
+	aesGCM, err := newGCM(key)
 	if err != nil {
-		panic(err.Error())
+		return "", err
 	}
+	if len(sealed) < aesGCM.NonceSize()+aesGCM.Overhead() {
+		return "", fmt.Errorf("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:aesGCM.NonceSize()], sealed[aesGCM.NonceSize():]
+	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return "", fmt.Errorf("ciphertext does not authenticate; wrong key, context or corrupted data")
+	}
+	return string(plaintext), nil
+}
 
-	//Get the nonce size
-	nonceSize := aesGCM.NonceSize()
-
-	//Extract the nonce from the encrypted data
-	nonce, ciphertext := enc[:nonceSize], enc[nonceSize:]
-
-	//Decrypt the data
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+// Encrypt seals plaintext under a single hex key, in an envelope named after the key's fingerprint
+func Encrypt(stringToEncrypt string, keyString string, context EnvelopeContext) (string, error) {
+	ring, err := NewKeyring(KeyID(keyString), map[string]string{KeyID(keyString): keyString})
 	if err != nil {
-		panic(err.Error())
+		return "", err
 	}
+	return ring.Encrypt(stringToEncrypt, context)
+}
 
-	return fmt.Sprintf("%s", plaintext)
+// Decrypt opens what Encrypt wrote with the same key and context, or a bare hex ciphertext of that key
+func Decrypt(encryptedString string, keyString string, context EnvelopeContext) (string, error) {
+	ring, err := NewKeyring(KeyID(keyString), map[string]string{KeyID(keyString): keyString})
+	if err != nil {
+		return "", err
+	}
+	return ring.Decrypt(encryptedString, context)
 }
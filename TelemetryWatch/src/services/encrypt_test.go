@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func generateKey(t *testing.T) string {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	key := generateKey(t)
+	contexts := []EnvelopeContext{
+		{},
+		{Collator: "main", From: "node-a", To: "node-b", Nonce: 5},
+	}
+	for _, context := range contexts {
+		for _, plain := range []string{"", "0x" + strings.Repeat("a1", 120), "ünïcode"} {
+			encrypted, err := Encrypt(plain, key, context)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encrypted, "v1."+KeyID(key)+".A256GCM.") {
+				t.Errorf("envelope %s", encrypted)
+			}
+			again, _ := Encrypt(plain, key, context)
+			if again == encrypted {
+				t.Error("two envelopes of the same plain text are equal")
+			}
+			decrypted, err := Decrypt(encrypted, key, context)
+			if err != nil || decrypted != plain {
+				t.Errorf("decrypted %q (%v) instead of %q", decrypted, err, plain)
+			}
+		}
+	}
+}
+
+func TestEnvelopeRejects(t *testing.T) {
+	key := generateKey(t)
+	context := EnvelopeContext{Collator: "main", From: "node-a", To: "node-b", Nonce: 5}
+	encrypted, err := Encrypt("0xsigned", key, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(encrypted, ".")
+	otherKey := generateKey(t)
+	tamperedData := append([]string{}, parts...)
+	tamperedData[3] = strings.Split(mustEncrypt(t, "x", key, EnvelopeContext{Collator: "main", From: "node-a", To: "node-c", Nonce: 5}), ".")[3]
+	sealed := []byte(parts[4])
+	if sealed[len(sealed)-1] == '0' {
+		sealed[len(sealed)-1] = '1'
+	} else {
+		sealed[len(sealed)-1] = '0'
+	}
+
+	cases := []struct {
+		name      string
+		encrypted string
+		key       string
+		context   EnvelopeContext
+	}{
+		{"other collator", encrypted, key, EnvelopeContext{Collator: "other", From: "node-a", To: "node-b", Nonce: 5}},
+		{"other from", encrypted, key, EnvelopeContext{Collator: "main", From: "node-c", To: "node-b", Nonce: 5}},
+		{"other to", encrypted, key, EnvelopeContext{Collator: "main", From: "node-a", To: "node-c", Nonce: 5}},
+		{"other nonce", encrypted, key, EnvelopeContext{Collator: "main", From: "node-a", To: "node-b", Nonce: 6}},
+		{"no context", encrypted, key, EnvelopeContext{}},
+		{"relabelled context", strings.Join(tamperedData, "."), key, EnvelopeContext{Collator: "main", From: "node-a", To: "node-c", Nonce: 5}},
+		{"tampered data", strings.Join(append(parts[:4:4], string(sealed)), "."), key, context},
+		{"other key", encrypted, otherKey, context},
+		{"relabelled key", strings.Replace(encrypted, KeyID(key), KeyID(otherKey), 1), otherKey, context},
+		{"other algorithm", strings.Replace(encrypted, ".A256GCM.", ".A128GCM.", 1), key, context},
+		{"missing part", strings.Join(parts[:4], "."), key, context},
+		{"not hex", strings.Join(append(parts[:4:4], "zz"), "."), key, context},
+	}
+	for _, c := range cases {
+		if plain, err := Decrypt(c.encrypted, c.key, c.context); err == nil {
+			t.Errorf("%s: decrypted %q", c.name, plain)
+		}
+	}
+	if _, err = Encrypt("x", "not a key", context); err == nil {
+		t.Error("encrypted with a key that is not hex")
+	}
+	if _, err = Encrypt("x", "abcd", context); err == nil {
+		t.Error("encrypted with a short key")
+	}
+}
+
+func mustEncrypt(t *testing.T, plain string, key string, context EnvelopeContext) string {
+	encrypted, err := Encrypt(plain, key, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
+
+// during a rotation the ring decrypts under the old and the new key, and encrypts under the primary one only
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKey := generateKey(t), generateKey(t)
+	context := EnvelopeContext{Collator: "main", From: "node-a", To: "node-b", Nonce: 5}
+	before, err := NewKeyring("2023", map[string]string{"2023": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	during, err := NewKeyring("2024", map[string]string{"2023": oldKey, "2024": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewKeyring("2024", map[string]string{"2024": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := before.Encrypt("0xold", context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := during.Decrypt(old, context); err != nil || plain != "0xold" {
+		t.Errorf("old envelope during the rotation: %q (%v)", plain, err)
+	}
+	current, err := during.Encrypt("0xnew", context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(current, "v1.2024.") {
+		t.Errorf("encrypted under %s", current)
+	}
+	if plain, err := after.Decrypt(current, context); err != nil || plain != "0xnew" {
+		t.Errorf("new envelope after the rotation: %q (%v)", plain, err)
+	}
+	if _, err := after.Decrypt(old, context); err == nil || !strings.Contains(err.Error(), "unknown key 2023") {
+		t.Errorf("old envelope after the rotation: %v", err)
+	}
+
+	invalid := []struct {
+		primary string
+		keys    map[string]string
+	}{
+		{"2025", map[string]string{"2024": newKey}},
+		{"20.24", map[string]string{"20.24": newKey}},
+		{"", map[string]string{"": newKey}},
+		{"2024", map[string]string{"2024": newKey[:32]}},
+		{"2024", map[string]string{"2024": "zz" + newKey[2:]}},
+	}
+	for _, c := range invalid {
+		if _, err := NewKeyring(c.primary, c.keys); err == nil {
+			t.Errorf("keyring %s %v accepted", c.primary, c.keys)
+		}
+	}
+}
+
+// legacySeal writes a ciphertext as before the envelope: bare hex of the nonce and the sealed data
+func legacySeal(t *testing.T, plain string, keyString string) string {
+	key, _ := hex.DecodeString(keyString)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	for i := range nonce {
+		nonce[i] = byte(i)
+	}
+	return hex.EncodeToString(aesGCM.Seal(nonce, nonce, []byte(plain), nil))
+}
+
+func TestLegacyCiphertexts(t *testing.T) {
+	key := generateKey(t)
+	legacy := legacySeal(t, "0xlegacy", key)
+	if plain, err := Decrypt(legacy, key, EnvelopeContext{}); err != nil || plain != "0xlegacy" {
+		t.Errorf("legacy ciphertext: %q (%v)", plain, err)
+	}
+	ring, err := NewKeyring("new", map[string]string{"new": generateKey(t), "old": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := ring.Decrypt(legacy, EnvelopeContext{}); err != nil || plain != "0xlegacy" {
+		t.Errorf("legacy ciphertext under a ring: %q (%v)", plain, err)
+	}
+	// a legacy ciphertext carries no context, so it cannot stand in where one is expected
+	if _, err := Decrypt(legacy, key, EnvelopeContext{Collator: "main", From: "node-a", To: "node-b", Nonce: 5}); err == nil {
+		t.Error("legacy ciphertext decrypted in a context")
+	}
+	if _, err := Decrypt(legacy, generateKey(t), EnvelopeContext{}); err == nil {
+		t.Error("legacy ciphertext decrypted with another key")
+	}
+	if _, err := Decrypt("not hex", key, EnvelopeContext{}); err == nil {
+		t.Error("decrypted garbage")
+	}
+}
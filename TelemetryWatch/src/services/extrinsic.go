@@ -0,0 +1,233 @@
+package services
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Moonriver runtime indices used by the reassociation transactions
+const (
+	ProxyPalletIndex         = 31 // pallet_proxy
+	ProxyCallIndex           = 0  // proxy.proxy(real, forceProxyType, call)
+	AuthorMappingPalletIndex = 23 // pallet_author_mapping
+	UpdateAssociationIndex   = 1  // authorMapping.updateAssociation(old, new)
+	ProxyTypeAuthorMapping   = 6  // ProxyType::AuthorMapping
+)
+
+const extrinsicVersion = 4
+const signedBit = 0x80
+
+// Call is an encoded runtime call; Args holds the still encoded arguments
+type Call struct {
+	Pallet byte
+	Method byte
+	Args   []byte
+}
+
+// Extrinsic is a decoded signed Moonbeam extrinsic (AccountId20 signer, EthereumSignature)
+type Extrinsic struct {
+	Signer    string // 0x-prefixed H160 address
+	Signature []byte // 65 bytes r, s, v
+	Era       []byte // 0x00 for immortal, 2 bytes for mortal
+	Nonce     int
+	Tip       uint64
+	Call      Call
+}
+
+// ProxyCall holds the arguments of proxy.proxy
+type ProxyCall struct {
+	Real           string // 0x-prefixed H160 address of the proxied (collator) account
+	ForceProxyType int    // -1 if none was given
+	Call           Call
+}
+
+// AssociationCall holds the arguments of authorMapping.updateAssociation
+type AssociationCall struct {
+	Old string // 0x-prefixed session (nimbus) key
+	New string
+}
+
+// DecodeExtrinsic decodes a hex encoded, length prefixed, signed extrinsic
+func DecodeExtrinsic(tx string) (*Extrinsic, error) {
+	raw, err := DecodeHex(tx)
+	if err != nil {
+		return nil, err
+	}
+	r := &scaleReader{data: raw}
+	length, err := r.readCompact()
+	if err != nil {
+		return nil, err
+	}
+	if int(length) != r.remaining() {
+		return nil, fmt.Errorf("extrinsic length prefix %d does not match payload of %d bytes", length, r.remaining())
+	}
+	version, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if version&signedBit == 0 {
+		return nil, fmt.Errorf("extrinsic is not signed")
+	}
+	if version&^signedBit != extrinsicVersion {
+		return nil, fmt.Errorf("unsupported extrinsic version %d", version&^signedBit)
+	}
+
+	ext := Extrinsic{}
+	signer, err := r.readBytes(20)
+	if err != nil {
+		return nil, err
+	}
+	ext.Signer = EncodeHex(signer)
+	if ext.Signature, err = r.readBytes(65); err != nil {
+		return nil, err
+	}
+	era, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	ext.Era = []byte{era}
+	if era != 0 {
+		next, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		ext.Era = append(ext.Era, next)
+	}
+	nonce, err := r.readCompact()
+	if err != nil {
+		return nil, err
+	}
+	ext.Nonce = int(nonce)
+	if ext.Tip, err = r.readCompact(); err != nil {
+		return nil, err
+	}
+	if ext.Call, err = decodeCall(r, r.remaining()); err != nil {
+		return nil, err
+	}
+	return &ext, nil
+}
+
+func decodeCall(r *scaleReader, length int) (Call, error) {
+	b, err := r.readBytes(length)
+	if err != nil {
+		return Call{}, err
+	}
+	if len(b) < 2 {
+		return Call{}, fmt.Errorf("call is too short")
+	}
+	return Call{Pallet: b[0], Method: b[1], Args: b[2:]}, nil
+}
+
+// DecodeProxy decodes the arguments of a proxy.proxy call
+func (c Call) DecodeProxy() (*ProxyCall, error) {
+	if c.Pallet != ProxyPalletIndex || c.Method != ProxyCallIndex {
+		return nil, fmt.Errorf("call %d.%d is not proxy.proxy", c.Pallet, c.Method)
+	}
+	r := &scaleReader{data: c.Args}
+	realAccount, err := r.readBytes(20)
+	if err != nil {
+		return nil, err
+	}
+	proxy := ProxyCall{Real: EncodeHex(realAccount), ForceProxyType: -1}
+	option, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if option == 1 {
+		proxyType, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		proxy.ForceProxyType = int(proxyType)
+	} else if option != 0 {
+		return nil, fmt.Errorf("invalid option byte %d for forceProxyType", option)
+	}
+	if proxy.Call, err = decodeCall(r, r.remaining()); err != nil {
+		return nil, err
+	}
+	return &proxy, nil
+}
+
+// DecodeUpdateAssociation decodes the arguments of an authorMapping.updateAssociation call
+func (c Call) DecodeUpdateAssociation() (*AssociationCall, error) {
+	if c.Pallet != AuthorMappingPalletIndex || c.Method != UpdateAssociationIndex {
+		return nil, fmt.Errorf("call %d.%d is not authorMapping.updateAssociation", c.Pallet, c.Method)
+	}
+	if len(c.Args) != 64 {
+		return nil, fmt.Errorf("updateAssociation arguments are %d bytes, expected 64", len(c.Args))
+	}
+	return &AssociationCall{Old: EncodeHex(c.Args[:32]), New: EncodeHex(c.Args[32:])}, nil
+}
+
+// Encode returns the SCALE encoding of the call
+func (c Call) Encode() []byte {
+	return append([]byte{c.Pallet, c.Method}, c.Args...)
+}
+
+// ProxyCallFor wraps call in proxy.proxy(realAccount, Some(proxyType), call)
+func ProxyCallFor(realAccount string, proxyType int, call Call) (Call, error) {
+	raw, err := DecodeHex(realAccount)
+	if err != nil {
+		return Call{}, err
+	}
+	if len(raw) != 20 {
+		return Call{}, fmt.Errorf("account %s is not a 20 byte address", realAccount)
+	}
+	args := append(raw, 1, byte(proxyType))
+	return Call{Pallet: ProxyPalletIndex, Method: ProxyCallIndex, Args: append(args, call.Encode()...)}, nil
+}
+
+// SigningPayload builds the payload an immortal transaction signs:
+// call, era, nonce, tip, then spec version, tx version, genesis hash and checkpoint (genesis for immortal txs)
+func SigningPayload(call Call, nonce int, version *RuntimeVersion, genesisHash string) ([]byte, error) {
+	genesis, err := DecodeHex(genesisHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(genesis) != 32 {
+		return nil, fmt.Errorf("genesis hash is %d bytes, expected 32", len(genesis))
+	}
+	payload := call.Encode()
+	payload = append(payload, 0x00) // immortal era
+	payload = append(payload, encodeCompact(uint64(nonce))...)
+	payload = append(payload, encodeCompact(0)...) // tip
+	payload = append(payload, encodeU32(uint32(version.SpecVersion))...)
+	payload = append(payload, encodeU32(uint32(version.TransactionVersion))...)
+	payload = append(payload, genesis...)
+	payload = append(payload, genesis...)
+	if len(payload) > 256 {
+		hash := blake2b.Sum256(payload)
+		return hash[:], nil
+	}
+	return payload, nil
+}
+
+// EncodeSignedExtrinsic serializes an immortal, zero tip, signed extrinsic as length prefixed hex
+func EncodeSignedExtrinsic(signer string, signature []byte, nonce int, call Call) (string, error) {
+	address, err := DecodeHex(signer)
+	if err != nil {
+		return "", err
+	}
+	if len(address) != 20 || len(signature) != 65 {
+		return "", fmt.Errorf("bad signer or signature length")
+	}
+	body := []byte{signedBit | extrinsicVersion}
+	body = append(body, address...)
+	body = append(body, signature...)
+	body = append(body, 0x00) // immortal era
+	body = append(body, encodeCompact(uint64(nonce))...)
+	body = append(body, encodeCompact(0)...) // tip
+	body = append(body, call.Encode()...)
+	return EncodeHex(append(encodeCompact(uint64(len(body))), body...)), nil
+}
+
+// ExtrinsicHash returns the 0x-prefixed blake2b-256 hash a node reports for a hex encoded extrinsic
+func ExtrinsicHash(tx string) (string, error) {
+	raw, err := DecodeHex(tx)
+	if err != nil {
+		return "", err
+	}
+	hash := blake2b.Sum256(raw)
+	return EncodeHex(hash[:]), nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+/**
+A signed Moonriver extrinsic laid out field by field, as OfflineTxMaker submits it:
+proxy.proxy(0xaa..aa, Some(AuthorMapping), authorMapping.updateAssociation(0x11..11, 0x22..22))
+signed by the EIP-155 example key at nonce 5, immortal, without tip
+**/
+var presignedExtrinsic = "0x" +
+	"cd02" + // compact length, 179 bytes
+	"84" + // signed, version 4
+	"9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f" + // signer (AccountId20)
+	"28ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276" + // signature r
+	"67cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83" + // s
+	"00" + // v
+	"00" + // immortal era
+	"14" + // compact nonce 5
+	"00" + // compact tip 0
+	"1f00" + // proxy.proxy
+	strings.Repeat("aa", 20) + // real
+	"0106" + // Some(AuthorMapping)
+	"1701" + // authorMapping.updateAssociation
+	strings.Repeat("11", 32) + // old
+	strings.Repeat("22", 32) // new
+
+func TestDecodeExtrinsic(t *testing.T) {
+	ext, err := DecodeExtrinsic(presignedExtrinsic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.Signer != "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f" {
+		t.Errorf("signer %s", ext.Signer)
+	}
+	if len(ext.Signature) != 65 || ext.Signature[0] != 0x28 || ext.Signature[64] != 0 {
+		t.Errorf("signature %s", EncodeHex(ext.Signature))
+	}
+	if EncodeHex(ext.Era) != "0x00" || ext.Nonce != 5 || ext.Tip != 0 {
+		t.Errorf("era %s nonce %d tip %d", EncodeHex(ext.Era), ext.Nonce, ext.Tip)
+	}
+	proxy, err := ext.Call.DecodeProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy.Real != proxiedAccount || proxy.ForceProxyType != ProxyTypeAuthorMapping {
+		t.Errorf("real %s proxy type %d", proxy.Real, proxy.ForceProxyType)
+	}
+	association, err := proxy.Call.DecodeUpdateAssociation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if association.Old != sessionFrom.Session || association.New != sessionTo.Session {
+		t.Errorf("association %s -> %s", association.Old, association.New)
+	}
+
+	// the encoder writes the same bytes
+	tx, err := EncodeSignedExtrinsic(ext.Signer, ext.Signature, ext.Nonce, ext.Call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx != presignedExtrinsic {
+		t.Errorf("encoded\n%s\nexpected\n%s", tx, presignedExtrinsic)
+	}
+}
+
+func TestDecodeExtrinsicRejects(t *testing.T) {
+	body := strings.TrimPrefix(presignedExtrinsic, "0x")[4:]
+	cases := map[string]string{
+		"not hex":       "0xzz",
+		"empty":         "0x",
+		"truncated":     presignedExtrinsic[:len(presignedExtrinsic)-2],
+		"trailing data": presignedExtrinsic + "00",
+		"unsigned":      "0xcd02" + "04" + body[2:],
+		"version 3":     "0xcd02" + "83" + body[2:],
+	}
+	for name, tx := range cases {
+		if _, err := DecodeExtrinsic(tx); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
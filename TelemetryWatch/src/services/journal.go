@@ -0,0 +1,243 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/chilts/sid"
+)
+
+// Outcomes of a journaled reassociation
+const (
+	OutcomeInFlight     = ""              // submitted, not verified yet
+	OutcomeActivated    = "activated"     // candidate session became active
+	OutcomeNotActivated = "not activated" // candidate session did not become active in time
+	OutcomeBlocked      = "blocked"       // not submitted (validation or safety check failed)
+	OutcomeDone         = "done"          // workflow step completed
+	OutcomeFailed       = "failed"        // workflow step failed
+	OutcomeRolledBack   = "rolled back"   // workflow step undone after a later failure
+)
+
+// Kinds of journal entries
+const (
+	KindReassociation = ""         // one reassociation attempt
+	KindWorkflow      = "workflow" // one step of a workflow such as a rolling upgrade
+)
+
+// NodeHealth is the state of one node when a failover was triggered
+type NodeHealth struct {
+	NodeName  string `json:"nodeName"`
+	Priority  int    `json:"priority"`
+	Imported  int    `json:"imported"`  // last imported block seen on telemetry
+	Finalized int    `json:"finalized"` // last finalized block seen on telemetry
+	Active    bool   `json:"active"`    // associated at the time
+	NotSynced bool   `json:"notSynced"`
+}
+
+// JournalEntry is the audit record of one reassociation attempt, from detection to verification,
+// or of one workflow step
+type JournalEntry struct {
+	ID          string       `json:"id"`
+	Revision    int          `json:"revision"` // bumped on every append; the highest revision wins
+	Kind        string       `json:"kind"`
+	Workflow    string       `json:"workflow"` // id of the workflow run the entry belongs to, if any
+	Step        string       `json:"step"`     // workflow step
+	Collator    string       `json:"collator"`
+	GroupName   string       `json:"groupName"`
+	Trigger     string       `json:"trigger"` // why a failover was started
+	Health      []NodeHealth `json:"health"`  // snapshot of every node of the group
+	From        string       `json:"from"`    // node names
+	To          string       `json:"to"`
+	Reason      string       `json:"reason"` // why this candidate (and proxy) was chosen
+	Proxy       string       `json:"proxy"`
+	Nonce       int          `json:"nonce"`
+	TxHash      string       `json:"txHash"` // empty if only the encrypted tx was available
+	Paths       []string     `json:"paths"`  // where the tx was submitted
+	SubmitError string       `json:"submitError"`
+	Outcome     string       `json:"outcome"`
+	DetectedAt  int64        `json:"detectedAt"`  // unix seconds
+	SubmittedAt int64        `json:"submittedAt"` // unix seconds; time of the attempt if it was blocked
+	CompletedAt int64        `json:"completedAt"` // unix seconds, 0 while in flight
+	SelectMs    int64        `json:"selectMs"`    // detection to submission
+	VerifyMs    int64        `json:"verifyMs"`    // submission to verification
+	LeaderToken int64        `json:"leaderToken"` // fencing token of the lease held by the watcher that acted, 0 without HA
+	Dissent     []string     `json:"dissent"`     // verdicts of the watchers that found the node healthy
+}
+
+// JournalStore is an append-only store of journal entries
+type JournalStore interface {
+	// Append stores a new revision of entry
+	Append(entry *JournalEntry) error
+	// Query returns the latest revision of the entries of collator ("" for all) submitted
+	// within [since, until] (unix seconds, 0 for open ended), oldest first
+	Query(collator string, since int64, until int64) ([]*JournalEntry, error)
+}
+
+var onceJournal sync.Once
+var journal JournalStore
+
+func initializeJournal() {
+	switch Config().JOURNAL_BACKEND {
+	case "dynamodb":
+		journal = &dynamoJournal{table: Config().JOURNAL_TABLE}
+	case "file", "":
+		journal = &fileJournal{path: Config().JOURNAL_FILE}
+	default:
+		panic(fmt.Errorf("unknown JOURNAL_BACKEND %s", Config().JOURNAL_BACKEND))
+	}
+}
+
+// Journal returns the journal store selected by JOURNAL_BACKEND
+func Journal() JournalStore {
+	onceJournal.Do(initializeJournal)
+	return journal
+}
+
+// NewJournalID returns a sortable unique id for a journal entry
+func NewJournalID() string {
+	return sid.Id()
+}
+
+// latestRevisions keeps the highest revision of every entry that matches the query, oldest first
+func latestRevisions(entries []*JournalEntry, collator string, since int64, until int64) []*JournalEntry {
+	byID := map[string]*JournalEntry{}
+	for _, entry := range entries {
+		if existing, ok := byID[entry.ID]; !ok || entry.Revision >= existing.Revision {
+			byID[entry.ID] = entry
+		}
+	}
+	out := []*JournalEntry{}
+	for _, entry := range byID {
+		if collator != "" && entry.Collator != collator {
+			continue
+		}
+		if entry.SubmittedAt < since || (until > 0 && entry.SubmittedAt > until) {
+			continue
+		}
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].SubmittedAt != out[j].SubmittedAt {
+			return out[i].SubmittedAt < out[j].SubmittedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// fileJournal stores one JSON entry per line; it is only ever appended to
+type fileJournal struct {
+	path string
+	mx   sync.Mutex
+}
+
+func (j *fileJournal) Append(entry *JournalEntry) error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	entry.Revision++
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (j *fileJournal) Query(collator string, since int64, until int64) ([]*JournalEntry, error) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return []*JournalEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*JournalEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // a torn last line after a crash must not hide the rest of the journal
+		}
+		entries = append(entries, &entry)
+	}
+	return latestRevisions(entries, collator, since, until), scanner.Err()
+}
+
+/**
+dynamoJournal stores every revision as its own item, so nothing is ever overwritten.
+The table has hash key `collator` and range key `sk` = zero padded submittedAt # id # revision,
+which makes time range queries per collator a key condition
+**/
+type dynamoJournal struct {
+	table string
+	mx    sync.Mutex
+}
+
+func (j *dynamoJournal) Append(entry *JournalEntry) error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	entry.Revision++
+	item, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+	item["sk"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("%012d#%s#%06d", entry.SubmittedAt, entry.ID, entry.Revision))}
+	_, err = DynamoDB().PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(j.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	})
+	return err
+}
+
+func (j *dynamoJournal) Query(collator string, since int64, until int64) ([]*JournalEntry, error) {
+	var items []AWSObject
+	var err error
+	if collator == "" {
+		items, err = ScanItems(&dynamodb.ScanInput{TableName: aws.String(j.table)}, 0, 1000)
+	} else {
+		if until == 0 {
+			until = 999999999999
+		}
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(j.table),
+			KeyConditionExpression: aws.String("collator = :c AND sk BETWEEN :a AND :b"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":c": {S: aws.String(collator)},
+				":a": {S: aws.String(fmt.Sprintf("%012d", since))},
+				":b": {S: aws.String(fmt.Sprintf("%012d~", until))},
+			},
+		}
+		err = DynamoDB().QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			items = append(items, page.Items...)
+			return true
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []*JournalEntry{}
+	if err = dynamodbattribute.UnmarshalListOfMaps(items, &entries); err != nil {
+		return nil, err
+	}
+	return latestRevisions(entries, collator, since, until), nil
+}
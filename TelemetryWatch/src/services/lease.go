@@ -0,0 +1,308 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const leaseName = "movrfailover-leader"
+
+// Lease is the leadership among several watchers. Token grows every time the lease changes hands,
+// so actions taken under an older token can be told apart (and refused) once another watcher leads
+type Lease struct {
+	Holder  string `json:"holder"`
+	Token   int64  `json:"token"`
+	Expires int64  `json:"expires"` // unix seconds
+}
+
+// HeldBy reports whether holder holds the lease at now
+func (l *Lease) HeldBy(holder string, now int64) bool {
+	return l != nil && l.Holder == holder && now < l.Expires
+}
+
+// LeaseStore elects one leader among the watchers sharing it
+type LeaseStore interface {
+	// Acquire renews the lease of holder, or takes it over if it is free or expired.
+	// It returns the lease as it is after the call, held by holder or not
+	Acquire(holder string, ttl time.Duration) (*Lease, error)
+	// Get returns the current lease, nil if it was never taken
+	Get() (*Lease, error)
+	// Release gives the lease up if holder holds it, so another watcher can take over at once
+	Release(holder string) error
+}
+
+var onceLease sync.Once
+var leaseStore LeaseStore
+
+func initializeLease() {
+	switch Config().LEASE_BACKEND {
+	case "":
+		leaseStore = nil // a single watcher
+	case "dynamodb":
+		leaseStore = &dynamoLease{table: Config().LEASE_TABLE}
+	case "file":
+		leaseStore = &fileLease{path: Config().LEASE_FILE}
+	case "memory":
+		leaseStore = NewMemoryLease()
+	default:
+		panic(fmt.Errorf("unknown LEASE_BACKEND %s", Config().LEASE_BACKEND))
+	}
+}
+
+// Leases returns the lease store selected by LEASE_BACKEND, nil if this watcher runs alone
+func Leases() LeaseStore {
+	onceLease.Do(initializeLease)
+	return leaseStore
+}
+
+// WatcherID is the name of this watcher in the lease
+func WatcherID() string {
+	if Config().WATCHER_ID != "" {
+		return Config().WATCHER_ID
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return fmt.Sprintf("watcher-%d", os.Getpid())
+	}
+	return host
+}
+
+// nextLease is the lease holder gets from current at now, or nil if another watcher holds it
+func nextLease(current *Lease, holder string, ttl time.Duration, now int64) *Lease {
+	expires := time.Unix(now, 0).Add(ttl).Unix()
+	if current == nil {
+		return &Lease{Holder: holder, Token: 1, Expires: expires}
+	}
+	if current.Holder == holder {
+		return &Lease{Holder: holder, Token: current.Token, Expires: expires}
+	}
+	if now >= current.Expires {
+		return &Lease{Holder: holder, Token: current.Token + 1, Expires: expires}
+	}
+	return nil
+}
+
+/**
+dynamoLease keeps the lease in one item, changed only with conditional UpdateItems:
+a renewal requires that we are still the holder, a takeover that the lease expired
+**/
+type dynamoLease struct {
+	table string
+}
+
+func (d *dynamoLease) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {
+			S: aws.String(leaseName),
+		},
+	}
+}
+
+func (d *dynamoLease) Acquire(holder string, ttl time.Duration) (*Lease, error) {
+	now := time.Now().Unix()
+	values := map[string]*dynamodb.AttributeValue{
+		":h":   {S: aws.String(holder)},
+		":e":   {N: aws.String(strconv.FormatInt(time.Unix(now, 0).Add(ttl).Unix(), 10))},
+		":now": {N: aws.String(strconv.FormatInt(now, 10))},
+	}
+	names := map[string]*string{"#h": aws.String("holder"), "#e": aws.String("expires"), "#t": aws.String("token")}
+	renew := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       d.key(),
+		UpdateExpression:          aws.String("set #e = :e"),
+		ConditionExpression:       aws.String("#h = :h"),
+		ExpressionAttributeNames:  map[string]*string{"#h": names["#h"], "#e": names["#e"]},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":h": values[":h"], ":e": values[":e"]},
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	}
+	out, err := DynamoDB().UpdateItem(renew)
+	if isConditionFailed(err) {
+		values[":one"] = &dynamodb.AttributeValue{N: aws.String("1")}
+		values[":zero"] = &dynamodb.AttributeValue{N: aws.String("0")}
+		takeOver := &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(d.table),
+			Key:                       d.key(),
+			UpdateExpression:          aws.String("set #h = :h, #e = :e, #t = if_not_exists(#t, :zero) + :one"),
+			ConditionExpression:       aws.String("attribute_not_exists(#h) OR #e <= :now"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+		}
+		out, err = DynamoDB().UpdateItem(takeOver)
+		if isConditionFailed(err) {
+			return d.Get() // held by another watcher
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	lease := &Lease{}
+	err = dynamodbattribute.UnmarshalMap(out.Attributes, lease)
+	return lease, err
+}
+
+func (d *dynamoLease) Get() (*Lease, error) {
+	out, err := DynamoDB().GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            d.key(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	lease := &Lease{}
+	err = dynamodbattribute.UnmarshalMap(out.Item, lease)
+	return lease, err
+}
+
+func (d *dynamoLease) Release(holder string) error {
+	_, err := DynamoDB().UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       d.key(),
+		UpdateExpression:          aws.String("set #e = :zero"),
+		ConditionExpression:       aws.String("#h = :h"),
+		ExpressionAttributeNames:  map[string]*string{"#h": aws.String("holder"), "#e": aws.String("expires")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":h": {S: aws.String(holder)}, ":zero": {N: aws.String("0")}},
+	})
+	if isConditionFailed(err) {
+		return nil // not ours anymore
+	}
+	return err
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// fileLease keeps the lease in a JSON file, read and written under an exclusive flock
+type fileLease struct {
+	path string
+}
+
+// locked runs change on the lease under the file lock; change returns the lease to write, or nil to keep it
+func (f *fileLease) locked(change func(current *Lease) *Lease) (*Lease, error) {
+	var lease *Lease
+	err := updateLocked(f.path, func(raw []byte) ([]byte, error) {
+		if len(raw) > 0 {
+			lease = &Lease{}
+			if err := json.Unmarshal(raw, lease); err != nil {
+				return nil, fmt.Errorf("%s: %v", f.path, err)
+			}
+		}
+		next := change(lease)
+		if next == nil {
+			return nil, nil
+		}
+		lease = next
+		return json.Marshal(next)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lease, nil
+}
+
+/**
+updateLocked reads a file under an exclusive flock and writes back what change returns (nothing if nil),
+so watchers sharing the file (e.g. on NFS) never interleave their read-modify-writes
+**/
+func updateLocked(path string, change func(raw []byte) ([]byte, error)) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	raw, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if raw, err = change(raw); err != nil || raw == nil {
+		return err
+	}
+	if err = file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err = file.WriteAt(raw, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (f *fileLease) Acquire(holder string, ttl time.Duration) (*Lease, error) {
+	return f.locked(func(current *Lease) *Lease {
+		return nextLease(current, holder, ttl, time.Now().Unix())
+	})
+}
+
+func (f *fileLease) Get() (*Lease, error) {
+	return f.locked(func(current *Lease) *Lease {
+		return nil
+	})
+}
+
+func (f *fileLease) Release(holder string) error {
+	_, err := f.locked(func(current *Lease) *Lease {
+		if current == nil || current.Holder != holder {
+			return nil
+		}
+		return &Lease{Holder: holder, Token: current.Token, Expires: 0}
+	})
+	return err
+}
+
+// memoryLease elects a leader among the watchers of one process, for tests
+type memoryLease struct {
+	lease *Lease
+	mx    sync.Mutex
+}
+
+// NewMemoryLease returns an in-memory lease store
+func NewMemoryLease() LeaseStore {
+	return &memoryLease{}
+}
+
+func (m *memoryLease) Acquire(holder string, ttl time.Duration) (*Lease, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if next := nextLease(m.lease, holder, ttl, time.Now().Unix()); next != nil {
+		m.lease = next
+	}
+	lease := *m.lease
+	return &lease, nil
+}
+
+func (m *memoryLease) Get() (*Lease, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.lease == nil {
+		return nil, nil
+	}
+	lease := *m.lease
+	return &lease, nil
+}
+
+func (m *memoryLease) Release(holder string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.lease != nil && m.lease.Holder == holder {
+		m.lease.Expires = 0
+	}
+	return nil
+}
@@ -33,12 +33,12 @@ func initializePP() {
 	var err error
 	if ENVIR == "dev" {
 		sess, err = session.NewSession(&aws.Config{
-			Region:      aws.String("eu-central-1"),
+			Region:      aws.String(AWSRegion()),
 			Credentials: credentials.NewSharedCredentials("", "movrfailover"),
 		})
 	} else {
 		sess, err = session.NewSession(&aws.Config{
-			Region: aws.String("eu-central-1"),
+			Region: aws.String(AWSRegion()),
 		})
 	}
 	if err != nil {
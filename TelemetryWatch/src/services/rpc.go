@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuntimeVersion holds the fields of state_getRuntimeVersion that signed transactions depend on
+type RuntimeVersion struct {
+	SpecName           string `json:"specName"`
+	SpecVersion        int    `json:"specVersion"`
+	TransactionVersion int    `json:"transactionVersion"`
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+var rpcClient = &http.Client{Timeout: 10 * time.Second}
+
+// RPCEndpoint returns the node endpoint used for direct JSON-RPC queries on the chain of a group
+func RPCEndpoint(groupName string) string {
+	if endpoint, ok := Config().RPC_ENDPOINTS[groupName]; ok {
+		return endpoint
+	}
+	return Config().RPC_ENDPOINT
+}
+
+// RPCCall executes a JSON-RPC request against the node at endpoint and unmarshals the result into out
+func RPCCall(endpoint string, method string, params []interface{}, out interface{}) error {
+	if endpoint == "" {
+		return fmt.Errorf("no RPC endpoint is configured")
+	}
+	if params == nil {
+		params = []interface{}{}
+	}
+	jsonstr, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	resp, err := rpcClient.Post(endpoint, "application/json", bytes.NewBuffer(jsonstr))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	var answer rpcResponse
+	if err = json.Unmarshal(body, &answer); err != nil {
+		return err
+	}
+	if answer.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, answer.Error.Message, answer.Error.Code)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(answer.Result, out)
+}
+
+// GetRuntimeVersion returns the runtime version currently active on the chain behind endpoint
+func GetRuntimeVersion(endpoint string) (*RuntimeVersion, error) {
+	version := RuntimeVersion{}
+	err := RPCCall(endpoint, "state_getRuntimeVersion", nil, &version)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// GetGenesisHash returns the 0x-prefixed hash of block 0
+func GetGenesisHash(endpoint string) (string, error) {
+	var hash string
+	err := RPCCall(endpoint, "chain_getBlockHash", []interface{}{0}, &hash)
+	return hash, err
+}
+
+// GetBalance returns the free balance of an account in wei, using the ethereum RPC of Moonbeam nodes
+func GetBalance(endpoint string, account string) (*big.Int, error) {
+	var balance string
+	err := RPCCall(endpoint, "eth_getBalance", []interface{}{account, "latest"}, &balance)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := new(big.Int).SetString(strings.TrimPrefix(balance, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid balance %s", balance)
+	}
+	return value, nil
+}
+
+// GetPendingExtrinsics returns the hex extrinsics waiting in the node's transaction pool
+func GetPendingExtrinsics(endpoint string) ([]string, error) {
+	pending := []string{}
+	err := RPCCall(endpoint, "author_pendingExtrinsics", nil, &pending)
+	return pending, err
+}
+
+// GetBestBlockNumber returns the number of the node's best block
+func GetBestBlockNumber(endpoint string) (int, error) {
+	header := struct {
+		Number string `json:"number"`
+	}{}
+	err := RPCCall(endpoint, "chain_getHeader", nil, &header)
+	if err != nil {
+		return 0, err
+	}
+	number, err := strconv.ParseInt(strings.TrimPrefix(header.Number, "0x"), 16, 64)
+	return int(number), err
+}
+
+// GetBlockExtrinsics returns the hex extrinsics of the block at height number
+func GetBlockExtrinsics(endpoint string, number int) ([]string, error) {
+	var hash string
+	err := RPCCall(endpoint, "chain_getBlockHash", []interface{}{number}, &hash)
+	if err != nil {
+		return nil, err
+	}
+	block := struct {
+		Block struct {
+			Extrinsics []string `json:"extrinsics"`
+		} `json:"block"`
+	}{}
+	err = RPCCall(endpoint, "chain_getBlock", []interface{}{hash}, &block)
+	return block.Block.Extrinsics, err
+}
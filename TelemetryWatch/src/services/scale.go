@@ -0,0 +1,117 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// scaleReader decodes the subset of the SCALE codec needed to read extrinsics
+type scaleReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *scaleReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *scaleReader) readBytes(n int) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, fmt.Errorf("scale: need %d bytes at offset %d, have %d", n, r.pos, r.remaining())
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *scaleReader) readByte() (byte, error) {
+	b, err := r.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readCompact decodes a compact encoded unsigned integer; values above 64 bits are rejected
+func (r *scaleReader) readCompact() (uint64, error) {
+	first, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch first & 0x03 {
+	case 0x00:
+		return uint64(first >> 2), nil
+	case 0x01:
+		next, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint16([]byte{first, next}) >> 2), nil
+	case 0x02:
+		next, err := r.readBytes(3)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint32([]byte{first, next[0], next[1], next[2]}) >> 2), nil
+	}
+	n := int(first>>2) + 4
+	if n > 8 {
+		return 0, fmt.Errorf("scale: compact integer of %d bytes is too large", n)
+	}
+	b, err := r.readBytes(n)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 8)
+	copy(buf, b)
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// DecodeHex decodes a hex string with or without the 0x prefix
+func DecodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+}
+
+// EncodeHex returns the 0x-prefixed lowercase hex encoding of b
+func EncodeHex(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+// SameHex compares two hex strings ignoring case and the 0x prefix (e.g. checksummed addresses)
+func SameHex(a string, b string) bool {
+	trim := func(s string) string {
+		return strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+	}
+	return trim(a) == trim(b)
+}
+
+// encodeCompact encodes an unsigned integer in SCALE compact form
+func encodeCompact(v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return []byte{byte(v << 2)}
+	case v < 1<<14:
+		b := make([]byte, 2)
+		binary.LittleEndian.PutUint16(b, uint16(v<<2|0x01))
+		return b
+	case v < 1<<30:
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(v<<2|0x02))
+		return b
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	n := 8
+	for n > 4 && b[n-1] == 0 {
+		n--
+	}
+	return append([]byte{byte(n-4)<<2 | 0x03}, b[:n]...)
+}
+
+func encodeU32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/**
+Every session row carries the version of the schema it was written with (Schema, 0 for rows older than
+versioning). MigrateSession upgrades a row step by step to SessionSchema; `movrfailover sessions migrate`
+writes the upgraded rows back. Add a step to sessionMigrations (and bump SessionSchema) as the schema grows
+**/
+const SessionSchema = 2
+
+var sessionMigrations = []struct {
+	Description string
+	Migrate     func(session *Session) error
+}{
+	{ // 0 -> 1
+		Description: "record the collator and proxy that the row used implicitly",
+		Migrate: func(session *Session) error {
+			collator, err := CollatorOf(session)
+			if err != nil {
+				return err
+			}
+			if session.Collator == "" && collator.Name != DefaultCollator {
+				session.Collator = collator.Name
+			}
+			if session.Proxy == "" && len(collator.Proxies) > 0 {
+				session.Proxy = collator.Proxies[0]
+			}
+			return nil
+		},
+	},
+	{ // 1 -> 2
+		Description: "write keys and the proxy as lower case 0x hex",
+		Migrate: func(session *Session) error {
+			session.Session = normalizeHex(session.Session)
+			session.VrfKey = normalizeHex(session.VrfKey)
+			session.Proxy = normalizeHex(session.Proxy)
+			return nil
+		},
+	},
+}
+
+func normalizeHex(s string) string {
+	if s == "" {
+		return s
+	}
+	return "0x" + strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+}
+
+// MigrateSession upgrades a session to SessionSchema, returning the descriptions of the steps applied
+func MigrateSession(session *Session) ([]string, error) {
+	if session.Schema > SessionSchema {
+		return nil, fmt.Errorf("%s has schema %d, newer than %d", session.NodeName, session.Schema, SessionSchema)
+	}
+	applied := []string{}
+	for session.Schema < SessionSchema {
+		step := sessionMigrations[session.Schema]
+		if err := step.Migrate(session); err != nil {
+			return applied, fmt.Errorf("%s: schema %d -> %d: %v", session.NodeName, session.Schema, session.Schema+1, err)
+		}
+		applied = append(applied, fmt.Sprintf("%d -> %d: %s", session.Schema, session.Schema+1, step.Description))
+		session.Schema++
+	}
+	return applied, nil
+}
+
+// SchemaProblem is one finding of ValidateSessions; warnings do not make a session unusable
+type SchemaProblem struct {
+	NodeName string
+	Field    string
+	Problem  string
+	Warning  bool
+}
+
+func (p SchemaProblem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("%s: %s %s: %s", level, p.NodeName, p.Field, p.Problem)
+}
+
+// names end up in hostnames, URLs and shell commands (see actions.go), so they are kept to a safe charset
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+/**
+ValidateSessions checks the rows loaded from the session store, so a bad row shows up when it is loaded
+rather than during a failover. Sessions with errors cannot be protected; duplicated node names make
+every row with that name unusable, since telemetry cannot tell the nodes apart.
+Rows are checked as MigrateSession would write them, on a copy, so an old row that the watcher can
+still use is only warned about. Problems with the optional fields (vrfKey, agentAddress, endpoints)
+are warnings as well
+**/
+func ValidateSessions(sessions []*Session) []SchemaProblem {
+	problems := []SchemaProblem{}
+	add := func(session *Session, field string, warning bool, format string, args ...interface{}) {
+		problems = append(problems, SchemaProblem{NodeName: session.NodeName, Field: field, Problem: fmt.Sprintf(format, args...), Warning: warning})
+	}
+	seen := map[string]*Session{}
+	for _, stored := range sessions {
+		if stored.Schema > SessionSchema {
+			add(stored, "schema", false, "%d is newer than this watcher (%d)", stored.Schema, SessionSchema)
+		} else if stored.Schema < SessionSchema {
+			add(stored, "schema", true, "%d is older than %d, run `movrfailover sessions migrate`", stored.Schema, SessionSchema)
+		}
+		// a failing step leaves the copy partly migrated; what it failed on is reported below
+		session := copySession(stored)
+		MigrateSession(session)
+
+		if session.NodeName == "" {
+			add(session, "nodeName", false, "is empty")
+		} else if other, ok := seen[session.NodeName]; ok {
+			add(session, "nodeName", false, "duplicated in groups %s and %s", other.GroupName, session.GroupName)
+		} else if !namePattern.MatchString(session.NodeName) {
+			add(session, "nodeName", false, "%q may only hold letters, digits, '.', '_' and '-' (up to 63)", session.NodeName)
+		}
+		seen[session.NodeName] = session
+		if session.GroupName == "" {
+			add(session, "groupName", false, "is empty")
+		} else if !namePattern.MatchString(session.GroupName) {
+			add(session, "groupName", false, "%q may only hold letters, digits, '.', '_' and '-' (up to 63)", session.GroupName)
+		}
+		if session.Session == "" {
+			add(session, "session", false, "is empty")
+		} else if err := checkHex(session.Session, 32); err != nil {
+			add(session, "session", false, "%v", err)
+		}
+		if session.VrfKey != "" {
+			if err := checkHex(session.VrfKey, 32); err != nil {
+				add(session, "vrfKey", true, "%v", err)
+			}
+		}
+
+		collator, err := CollatorOf(session)
+		if err != nil {
+			add(session, "collator", false, "%v", err)
+		} else if session.Proxy == "" && len(collator.Proxies) == 0 {
+			add(session, "proxy", false, "is empty and collator %s has no proxies", collator.Name)
+		} else if session.Proxy != "" && len(collator.Proxies) > 0 && !collator.HasProxy(session.Proxy) {
+			add(session, "proxy", false, "%s is not a proxy of collator %s", session.Proxy, collator.Name)
+		}
+		if session.Proxy != "" {
+			if err := checkHex(session.Proxy, 20); err != nil {
+				add(session, "proxy", false, "%v", err)
+			}
+		}
+		if session.AgentAddress != "" {
+			if u, err := url.Parse(session.AgentAddress); err != nil || u.Scheme == "" || u.Host == "" {
+				add(session, "agentAddress", true, "%s is not an http address", session.AgentAddress)
+			}
+		}
+		for _, endpoint := range session.Endpoints {
+			if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+				add(session, "endpoints", true, "%s is not an address", endpoint)
+			}
+		}
+		if _, err := session.Inventory(); err != nil {
+			add(session, "transactions", false, "cannot be parsed: %v", err)
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].NodeName < problems[j].NodeName
+	})
+	return problems
+}
+
+// checkHex verifies that s is 0x-prefixed hex of size bytes
+func checkHex(s string, size int) error {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return fmt.Errorf("%s is not 0x-prefixed hex", s)
+	}
+	b, err := DecodeHex(s)
+	if err != nil {
+		return fmt.Errorf("%s is not hex", s)
+	}
+	if len(b) != size {
+		return fmt.Errorf("%s is %d bytes instead of %d", s, len(b), size)
+	}
+	return nil
+}
+
+// InvalidSessions returns the node names of the sessions with errors (not only warnings)
+func InvalidSessions(problems []SchemaProblem) map[string]bool {
+	invalid := map[string]bool{}
+	for _, problem := range problems {
+		if !problem.Warning {
+			invalid[problem.NodeName] = true
+		}
+	}
+	return invalid
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSessions(t *testing.T) {
+	useConfig(Configuration{COLLATORS: []Collator{{Name: "main", Proxies: []string{"0x" + strings.Repeat("aa", 20)}}}})
+	key := strings.Repeat("11", 32)
+	valid := func(nodeName string) *Session {
+		return &Session{NodeName: nodeName, GroupName: "moonriver", Session: "0x" + key, Schema: SessionSchema}
+	}
+
+	cases := []struct {
+		name    string
+		session *Session
+		field   string // "" when the row has no problem
+		warning bool
+	}{
+		{"current row", valid("node-a"), "", false},
+		{"legacy row without 0x", &Session{NodeName: "node-b", GroupName: "moonriver", Session: strings.ToUpper(key)}, "schema", true},
+		{"newer schema", &Session{NodeName: "node-c", GroupName: "moonriver", Session: "0x" + key, Schema: SessionSchema + 1}, "schema", false},
+		{"bad session", &Session{NodeName: "node-d", GroupName: "moonriver", Session: "0x1234", Schema: SessionSchema}, "session", false},
+		{"bad vrfKey", &Session{NodeName: "node-e", GroupName: "moonriver", Session: "0x" + key, VrfKey: "0x12", Schema: SessionSchema}, "vrfKey", true},
+		{"bad agentAddress", &Session{NodeName: "node-f", GroupName: "moonriver", Session: "0x" + key, AgentAddress: "10.0.0.5:9955", Schema: SessionSchema}, "agentAddress", true},
+		{"bad endpoint", &Session{NodeName: "node-g", GroupName: "moonriver", Session: "0x" + key, Endpoints: []string{"not an address"}, Schema: SessionSchema}, "endpoints", true},
+		{"shell in nodeName", valid("node;reboot"), "nodeName", false},
+		{"space in groupName", &Session{NodeName: "node-h", GroupName: "moon river", Session: "0x" + key, Schema: SessionSchema}, "groupName", false},
+		{"leading dash", valid("-oProxyCommand=x"), "nodeName", false},
+		{"unknown collator", &Session{NodeName: "node-i", GroupName: "moonriver", Session: "0x" + key, Collator: "other", Schema: SessionSchema}, "collator", false},
+	}
+	for _, c := range cases {
+		stored := *c.session
+		problems := ValidateSessions([]*Session{c.session})
+		if c.session.Session != stored.Session || c.session.Schema != stored.Schema {
+			t.Errorf("%s: validation changed the row", c.name)
+		}
+		if c.field == "" {
+			if len(problems) > 0 {
+				t.Errorf("%s: %v", c.name, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || problems[0].Field != c.field || problems[0].Warning != c.warning {
+			t.Errorf("%s: expected one %s problem (warning %v), got %v", c.name, c.field, c.warning, problems)
+			continue
+		}
+		if invalid := InvalidSessions(problems); invalid[c.session.NodeName] == c.warning {
+			t.Errorf("%s: invalid is %v", c.name, invalid[c.session.NodeName])
+		}
+	}
+
+	problems := ValidateSessions([]*Session{valid("node-a"), valid("node-a")})
+	if len(problems) != 1 || problems[0].Field != "nodeName" || problems[0].Warning {
+		t.Errorf("duplicated node name: %v", problems)
+	}
+}
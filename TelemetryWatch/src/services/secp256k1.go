@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+// secp256k1 ECDSA as used by Moonbeam's EthereumSignature: RFC 6979 nonces, low-s, recovery id.
+// The curve arithmetic is dcrd's constant-time implementation
+
+// Keccak256 is the legacy (pre-NIST) Keccak used by Ethereum and Moonbeam
+func Keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// EthereumKey is a secp256k1 private key with its H160 address
+type EthereumKey struct {
+	key     *secp256k1.PrivateKey
+	Address string // 0x-prefixed lowercase hex
+}
+
+// ParseEthereumKey reads a hex private key, with or without the 0x prefix
+func ParseEthereumKey(privateKey string) (*EthereumKey, error) {
+	raw, err := DecodeHex(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("private key is %d bytes, expected 32", len(raw))
+	}
+	var scalar secp256k1.ModNScalar
+	if overflow := scalar.SetByteSlice(raw); overflow || scalar.IsZero() {
+		return nil, fmt.Errorf("private key is out of range")
+	}
+	key := secp256k1.NewPrivateKey(&scalar)
+	// uncompressed public key without the 0x04 prefix: x || y
+	pubBytes := key.PubKey().SerializeUncompressed()[1:]
+	return &EthereumKey{key: key, Address: EncodeHex(Keccak256(pubBytes)[12:])}, nil
+}
+
+// Sign signs keccak256(message) and returns the 65 byte r || s || v signature (v is the recovery id 0 or 1)
+func (key *EthereumKey) Sign(message []byte) []byte {
+	// compact signatures are 27 + recovery id || r || s, with s already low
+	compact := ecdsa.SignCompact(key.key, Keccak256(message), false)
+	return append(compact[1:], compact[0]-27)
+}
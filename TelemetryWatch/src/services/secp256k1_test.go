@@ -0,0 +1,78 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// the signing example of EIP-155: same key, same legacy keccak, same curve as a Moonbeam EthereumSignature
+const eip155Key = "0x4646464646464646464646464646464646464646464646464646464646464646"
+const eip155Message = "0xec098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a764000080018080"
+
+func TestKeccak256(t *testing.T) {
+	cases := []struct {
+		data string
+		hash string
+	}{
+		{"0x", "0xc5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{eip155Message, "0xdaf5a779ae972f972197303d7b574746c7ef83eadac0f2791ad23db92e4c8e53"},
+	}
+	for _, c := range cases {
+		data, err := DecodeHex(c.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := EncodeHex(Keccak256(data)); got != c.hash {
+			t.Errorf("Keccak256(%s) = %s, expected %s", c.data, got, c.hash)
+		}
+	}
+}
+
+func TestParseEthereumKey(t *testing.T) {
+	key, err := ParseEthereumKey(eip155Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SameHex(key.Address, "0x9d8A62f656a8d1615C1294fd71e9CFb3E4855A4F") {
+		t.Errorf("address is %s", key.Address)
+	}
+	if key.Address != strings.ToLower(key.Address) {
+		t.Errorf("address %s is not lowercase", key.Address)
+	}
+
+	invalid := []string{
+		"0x",
+		"0x4646",
+		"0x0000000000000000000000000000000000000000000000000000000000000000",
+		"0xfffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", // the group order
+		"0xzz46464646464646464646464646464646464646464646464646464646464646",
+	}
+	for _, privateKey := range invalid {
+		if _, err := ParseEthereumKey(privateKey); err == nil {
+			t.Errorf("ParseEthereumKey(%s) accepted an invalid key", privateKey)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	key, err := ParseEthereumKey(eip155Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := DecodeHex(eip155Message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// r, s and v - 35 - 2 * chainId of the EIP-155 example
+	expected := "0x28ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276" +
+		"67cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83" +
+		"00"
+	signature := key.Sign(message)
+	if got := EncodeHex(signature); got != expected {
+		t.Errorf("signature is %s, expected %s", got, expected)
+	}
+	// RFC 6979 nonces: signing again gives the same signature
+	if again := EncodeHex(key.Sign(message)); again != expected {
+		t.Errorf("second signature is %s", again)
+	}
+}
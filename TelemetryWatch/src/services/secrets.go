@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
@@ -15,11 +17,11 @@ import (
 
 var onceSM sync.Once
 var svcSM *secretsmanager.SecretsManager
-var secretName = "YOUR-SECRET-NAME"
 
 type SecretKey struct {
 	ApiKey    string `json:"apikey_movrfailover1"`
 	KeyCaller string `json:"secretkey_movrfailover1"`
+	KeyEnv    string `json:"keyenv_movrfailover1"` // optional; only needed to validate presigned txs
 }
 
 func initializeSM() {
@@ -27,12 +29,12 @@ func initializeSM() {
 	var err error
 	if ENVIR == "dev" {
 		sess, err = session.NewSession(&aws.Config{
-			Region:      aws.String("eu-central-1"),
+			Region:      aws.String(AWSRegion()),
 			Credentials: credentials.NewSharedCredentials("", "movrfailover"),
 		})
 	} else {
 		sess, err = session.NewSession(&aws.Config{
-			Region: aws.String("eu-central-1"),
+			Region: aws.String(AWSRegion()),
 		})
 	}
 	if err != nil {
@@ -47,14 +49,174 @@ func SM() *secretsmanager.SecretsManager {
 	return svcSM
 }
 
+// version stages of the keys, named after the Secrets Manager ones
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePrevious = "AWSPREVIOUS"
+)
+
+// SecretsProvider fetches the keys from where they are kept
+type SecretsProvider interface {
+	// Fetch returns one version stage of the keys; nil without error if the stage has no keys
+	Fetch(stage string) (*SecretKey, error)
+}
+
+var onceSecrets sync.Once
+var secretsProvider SecretsProvider
+var spMX sync.RWMutex // mx for secretsProvider
+
+func initializeSecrets() {
+	spMX.Lock()
+	defer spMX.Unlock()
+	if secretsProvider != nil {
+		return // set with SetSecretsProvider
+	}
+	switch Config().SECRETS_PROVIDER {
+	case "aws", "":
+		name := Config().SECRET_NAME
+		if name == "" {
+			name = "YOUR-SECRET-NAME"
+		}
+		secretsProvider = &awsSecrets{name: name}
+	case "env":
+		secretsProvider = &envSecrets{}
+	case "file":
+		secretsProvider = &fileSecrets{path: Config().SECRETS_FILE}
+	case "vault":
+		secretsProvider = newVaultSecrets(Config().VAULT_ADDR, Config().VAULT_MOUNT, Config().VAULT_PATH)
+	case "memory":
+		secretsProvider = NewMemorySecrets(nil, nil)
+	default:
+		panic(fmt.Errorf("unknown SECRETS_PROVIDER %s", Config().SECRETS_PROVIDER))
+	}
+}
+
+// Secrets returns the provider selected by SECRETS_PROVIDER
+func Secrets() SecretsProvider {
+	onceSecrets.Do(initializeSecrets)
+	spMX.RLock()
+	defer spMX.RUnlock()
+	return secretsProvider
+}
+
+// SetSecretsProvider replaces the configured provider, e.g. with an in-memory one, and empties the key cache
+func SetSecretsProvider(provider SecretsProvider) {
+	spMX.Lock()
+	secretsProvider = provider
+	spMX.Unlock()
+	skMX.Lock()
+	cachedKeys, previousKeys, keysFetchedAt = nil, nil, time.Time{}
+	skMX.Unlock()
+}
+
+/**
+The keys are cached for SECRETS_TTL_IN_SECONDS so a failover does not wait on the secrets provider. When fetching
+fails, the cached keys are used past their TTL (stale rather than none). Both the AWSCURRENT and the
+AWSPREVIOUS versions are kept: while the secret is rotated, the REST API or the presigned txs may still
+expect the previous keys, and RefreshKeys is called when the current ones are refused
+**/
+var cachedKeys *SecretKey   // AWSCURRENT
+var previousKeys *SecretKey // AWSPREVIOUS, nil if the secret has a single version
+var keysFetchedAt time.Time
+var skMX sync.Mutex // mx for cachedKeys, previousKeys and keysFetchedAt
+
+const keysRetry = 30 * time.Second // wait before fetching again when fetching failed
+
+func keysTTL() time.Duration {
+	if Config().SECRETS_TTL_IN_SECONDS <= 0 {
+		return 300 * time.Second
+	}
+	return time.Duration(Config().SECRETS_TTL_IN_SECONDS) * time.Second
+}
+
+// Keys returns the current keys, from the cache while it is fresh
+func Keys() (*SecretKey, error) {
+	skMX.Lock()
+	keys, fresh := cachedKeys, time.Since(keysFetchedAt) < keysTTL()
+	skMX.Unlock()
+	if keys != nil && fresh {
+		return keys, nil
+	}
+	return RefreshKeys()
+}
+
+// PreviousKeys returns the keys of the version before the current one, nil if there is none
+func PreviousKeys() (*SecretKey, error) {
+	if _, err := Keys(); err != nil {
+		return nil, err
+	}
+	skMX.Lock()
+	defer skMX.Unlock()
+	return previousKeys, nil
+}
+
+// PrefetchKeys fills the cache at startup, so the first failover does not fetch the keys
+func PrefetchKeys() error {
+	_, err := RefreshKeys()
+	return err
+}
+
+// RefreshKeys fetches both versions of the keys; if that fails, the cached keys are returned with the error
+func RefreshKeys() (*SecretKey, error) {
+	current, err := Secrets().Fetch(StageCurrent)
+	if err == nil && current == nil {
+		err = fmt.Errorf("no keys in the secrets provider")
+	}
+	if err != nil {
+		skMX.Lock()
+		stale := cachedKeys
+		if stale != nil {
+			keysFetchedAt = time.Now().Add(keysRetry - keysTTL()) // retry later, not at every call
+		}
+		skMX.Unlock()
+		if stale != nil {
+			fmt.Printf("Cannot fetch the keys, using the cached ones: %v\n", err)
+			return stale, nil
+		}
+		return nil, err
+	}
+	previous, err := Secrets().Fetch(StagePrevious)
+	if err != nil {
+		previous = nil // a secret that was never rotated has no previous version
+	}
+	skMX.Lock()
+	cachedKeys = current
+	previousKeys = previous
+	keysFetchedAt = time.Now()
+	skMX.Unlock()
+	return current, nil
+}
+
 func GetKeys() (string, string, error) {
+	apiKey, keyCaller, _, err := GetAllKeys()
+	return apiKey, keyCaller, err
+}
+
+// GetAllKeys returns the api key, keyCaller and keyEnv (empty if not stored in the secret)
+func GetAllKeys() (string, string, string, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", "", "", err
+	}
+	return keys.ApiKey, keys.KeyCaller, keys.KeyEnv, nil
+}
+
+// awsSecrets reads the keys from a Secrets Manager secret
+type awsSecrets struct {
+	name string
+}
+
+func (a *awsSecrets) Fetch(stage string) (*SecretKey, error) {
 	input := &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretName),
-		VersionStage: aws.String("AWSCURRENT"), // VersionStage defaults to AWSCURRENT if unspecified
+		SecretId:     aws.String(a.name),
+		VersionStage: aws.String(stage),
 	}
 	result, err := SM().GetSecretValue(input)
 	if err != nil {
-		return "", "", err
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException && stage != StageCurrent {
+			return nil, nil
+		}
+		return nil, err
 	}
 	// Decrypts secret using the associated KMS CMK.
 	// Depending on whether the secret is a string or binary, one of these fields will be populated.
@@ -66,14 +228,14 @@ func GetKeys() (string, string, error) {
 		len, err := base64.StdEncoding.Decode(decodedBinarySecretBytes, result.SecretBinary)
 		if err != nil {
 			fmt.Println("Base64 Decode Error:", err)
-			return "", "", err
+			return nil, err
 		}
 		secretString = string(decodedBinarySecretBytes[:len])
 	}
 	var key SecretKey
 	err = json.Unmarshal([]byte(secretString), &key)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	return key.ApiKey, key.KeyCaller, nil
+	return &key, nil
 }
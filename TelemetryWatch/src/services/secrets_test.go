@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func keysOf(version int) *SecretKey {
+	return &SecretKey{ApiKey: fmt.Sprintf("api-%d", version), KeyCaller: fmt.Sprintf("caller-%d", version), KeyEnv: fmt.Sprintf("env-%d", version)}
+}
+
+// vaultServer serves versions 1..latest of a KV version 2 secret at secret/movrfailover, except the deleted ones
+func vaultServer(t *testing.T, latest int, deleted map[int]bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/movrfailover" || latest == 0 {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		version := latest
+		if r.URL.Query().Get("version") != "" {
+			version, _ = strconv.Atoi(r.URL.Query().Get("version"))
+		}
+		if version < 1 || version > latest || deleted[version] {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		answer := vaultResponse{}
+		answer.Data.Data = *keysOf(version)
+		answer.Data.Metadata.Version = version
+		json.NewEncoder(w).Encode(answer)
+	}))
+}
+
+func TestVaultSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err = ioutil.WriteFile(tokenFile, []byte("test-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(Configuration{VAULT_TOKEN_FILE: tokenFile})
+
+	cases := []struct {
+		name     string
+		latest   int
+		deleted  map[int]bool
+		path     string
+		current  *SecretKey // nil if fetching it fails
+		previous *SecretKey
+	}{
+		{"rotated", 3, nil, "movrfailover", keysOf(3), keysOf(2)},
+		{"never rotated", 1, nil, "movrfailover", keysOf(1), nil},
+		{"previous deleted", 3, map[int]bool{2: true}, "movrfailover", keysOf(3), nil},
+		{"no secret", 0, nil, "movrfailover", nil, nil},
+		{"other path", 2, nil, "other", nil, nil},
+	}
+	for _, c := range cases {
+		server := vaultServer(t, c.latest, c.deleted)
+		provider := newVaultSecrets(server.URL+"/", "", "/"+c.path)
+		current, err := provider.Fetch(StageCurrent)
+		if c.current == nil {
+			if err == nil {
+				t.Errorf("%s: fetched %+v", c.name, current)
+			}
+		} else if err != nil || current == nil || *current != *c.current {
+			t.Errorf("%s: current %+v (%v)", c.name, current, err)
+		}
+		previous, err := provider.Fetch(StagePrevious)
+		if err != nil {
+			t.Errorf("%s: previous: %v", c.name, err)
+		} else if (previous == nil) != (c.previous == nil) || (previous != nil && *previous != *c.previous) {
+			t.Errorf("%s: previous %+v instead of %+v", c.name, previous, c.previous)
+		}
+		server.Close()
+	}
+
+	// a token Vault refuses is an error, not a missing secret
+	server := vaultServer(t, 2, nil)
+	defer server.Close()
+	if err = ioutil.WriteFile(tokenFile, []byte("wrong-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider := newVaultSecrets(server.URL, "secret", "movrfailover")
+	for _, stage := range []string{StageCurrent, StagePrevious} {
+		if key, err := provider.Fetch(stage); err == nil {
+			t.Errorf("%s with a wrong token: %+v", stage, key)
+		}
+	}
+}
+
+func TestFileSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	raw, _ := json.Marshal(secretsFile{SecretKey: *keysOf(2), Previous: keysOf(1)})
+	if err = ioutil.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	provider := &fileSecrets{path: path}
+	if _, err = provider.Fetch(StageCurrent); err == nil {
+		t.Error("read a secrets file others can read")
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if current, err := provider.Fetch(StageCurrent); err != nil || *current != *keysOf(2) {
+		t.Errorf("current %+v (%v)", current, err)
+	}
+	if previous, err := provider.Fetch(StagePrevious); err != nil || *previous != *keysOf(1) {
+		t.Errorf("previous %+v (%v)", previous, err)
+	}
+}
+
+// failingSecrets fails while fail is set, and otherwise serves the keys of its provider
+type failingSecrets struct {
+	SecretsProvider
+	fail bool
+}
+
+func (f *failingSecrets) Fetch(stage string) (*SecretKey, error) {
+	if f.fail {
+		return nil, fmt.Errorf("provider is down")
+	}
+	return f.SecretsProvider.Fetch(stage)
+}
+
+func TestKeysCache(t *testing.T) {
+	useConfig(Configuration{})
+	memory := NewMemorySecrets(keysOf(2), keysOf(1))
+	if key, _ := memory.Fetch(StageCurrent); key == nil || *key != *keysOf(2) {
+		t.Fatalf("memory current %+v", key)
+	}
+	if key, _ := memory.Fetch(StagePrevious); key == nil || *key != *keysOf(1) {
+		t.Fatalf("memory previous %+v", key)
+	}
+	if key, err := NewMemorySecrets(keysOf(1), nil).Fetch(StagePrevious); err != nil || key != nil {
+		t.Errorf("memory without previous: %+v (%v)", key, err)
+	}
+
+	provider := &failingSecrets{SecretsProvider: memory}
+	SetSecretsProvider(provider)
+	defer SetSecretsProvider(NewMemorySecrets(nil, nil))
+	keys, err := Keys()
+	if err != nil || *keys != *keysOf(2) {
+		t.Fatalf("keys %+v (%v)", keys, err)
+	}
+	if previous, err := PreviousKeys(); err != nil || *previous != *keysOf(1) {
+		t.Errorf("previous keys %+v (%v)", previous, err)
+	}
+
+	// while the provider is down, the cached keys are used
+	provider.fail = true
+	if keys, err = RefreshKeys(); err != nil || *keys != *keysOf(2) {
+		t.Errorf("stale keys %+v (%v)", keys, err)
+	}
+
+	// a new provider empties the cache, so its failure shows
+	SetSecretsProvider(&failingSecrets{SecretsProvider: memory, fail: true})
+	if keys, err = Keys(); err == nil {
+		t.Errorf("keys without a provider: %+v", keys)
+	}
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+envSecrets reads the keys from environment variables: MOVRFAILOVER_API_KEY, MOVRFAILOVER_KEY_CALLER and
+MOVRFAILOVER_KEY_ENV (optional), with a _PREVIOUS suffix for the previous version during a rotation
+**/
+type envSecrets struct{}
+
+func (e *envSecrets) Fetch(stage string) (*SecretKey, error) {
+	suffix := ""
+	if stage == StagePrevious {
+		suffix = "_PREVIOUS"
+	}
+	key := &SecretKey{
+		ApiKey:    os.Getenv("MOVRFAILOVER_API_KEY" + suffix),
+		KeyCaller: os.Getenv("MOVRFAILOVER_KEY_CALLER" + suffix),
+		KeyEnv:    os.Getenv("MOVRFAILOVER_KEY_ENV" + suffix),
+	}
+	if key.ApiKey == "" && key.KeyCaller == "" {
+		if stage == StagePrevious {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("MOVRFAILOVER_API_KEY and MOVRFAILOVER_KEY_CALLER are not set")
+	}
+	return key, nil
+}
+
+// secretsFile is the layout of SECRETS_FILE: the keys as in the Secrets Manager secret, and optionally the previous ones
+type secretsFile struct {
+	SecretKey
+	Previous *SecretKey `json:"previous,omitempty"`
+}
+
+// fileSecrets reads the keys from a JSON file, refusing it if its mode lets anyone but the owner read it
+type fileSecrets struct {
+	path string
+}
+
+func (f *fileSecrets) Fetch(stage string) (*SecretKey, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s has mode %o, it must be 0600", f.path, info.Mode().Perm())
+	}
+	raw, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	file := &secretsFile{}
+	if err = json.Unmarshal(raw, file); err != nil {
+		return nil, fmt.Errorf("%s: %v", f.path, err)
+	}
+	if stage == StagePrevious {
+		return file.Previous, nil
+	}
+	key := file.SecretKey
+	return &key, nil
+}
+
+/**
+vaultSecrets reads the keys from the KV version 2 secrets engine of HashiCorp Vault over its HTTP API.
+The secret holds the same fields as the Secrets Manager one; the previous stage is the version before
+the current one
+**/
+type vaultSecrets struct {
+	addr   string
+	mount  string
+	path   string
+	client *http.Client
+}
+
+func newVaultSecrets(addr string, mount string, path string) *vaultSecrets {
+	if mount == "" {
+		mount = "secret"
+	}
+	return &vaultSecrets{
+		addr:   strings.TrimSuffix(addr, "/"),
+		mount:  strings.Trim(mount, "/"),
+		path:   strings.Trim(path, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type vaultResponse struct {
+	Data struct {
+		Data     SecretKey `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+func (v *vaultSecrets) Fetch(stage string) (*SecretKey, error) {
+	latest, err := v.read(0)
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		if stage == StagePrevious {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("no secret at %s/%s in vault", v.mount, v.path)
+	}
+	if stage != StagePrevious {
+		return &latest.Data.Data, nil
+	}
+	if latest.Data.Metadata.Version <= 1 {
+		return nil, nil
+	}
+	previous, err := v.read(latest.Data.Metadata.Version - 1)
+	if err != nil || previous == nil {
+		return nil, err
+	}
+	return &previous.Data.Data, nil
+}
+
+// read gets a version of the secret (0 for the latest), nil if it does not exist or was deleted
+func (v *vaultSecrets) read(version int) (*vaultResponse, error) {
+	token, err := vaultToken()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", v.addr, v.mount, v.path)
+	if version > 0 {
+		endpoint += "?" + url.Values{"version": {fmt.Sprintf("%d", version)}}.Encode()
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	answer := &vaultResponse{}
+	if err = json.Unmarshal(body, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// vaultToken reads the token from VAULT_TOKEN_FILE, or the VAULT_TOKEN environment variable
+func vaultToken() (string, error) {
+	if Config().VAULT_TOKEN_FILE == "" {
+		if token := os.Getenv("VAULT_TOKEN"); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("VAULT_TOKEN is not set")
+	}
+	raw, err := ioutil.ReadFile(Config().VAULT_TOKEN_FILE)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// memorySecrets keeps the keys in memory, for tests and tools
+type memorySecrets struct {
+	mx       sync.Mutex
+	current  *SecretKey
+	previous *SecretKey
+}
+
+// NewMemorySecrets returns a provider holding the given keys; previous may be nil
+func NewMemorySecrets(current *SecretKey, previous *SecretKey) SecretsProvider {
+	return &memorySecrets{current: current, previous: previous}
+}
+
+func (m *memorySecrets) Fetch(stage string) (*SecretKey, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	key := m.current
+	if stage == StagePrevious {
+		key = m.previous
+	}
+	if key == nil {
+		return nil, nil
+	}
+	c := *key
+	return &c, nil
+}
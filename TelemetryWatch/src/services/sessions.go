@@ -0,0 +1,525 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ghodss/yaml"
+)
+
+// SessionStore keeps the sessions (nodes) of all groups
+type SessionStore interface {
+	// List returns all sessions
+	List() ([]*Session, error)
+	// Get returns one session, nil if there is none with that node name
+	Get(nodeName string) (*Session, error)
+	// UpdateState sets a state attribute of a session (active, runtime, drain, fence), or removes it if value is nil
+	UpdateState(nodeName string, attribute string, value interface{}) error
+	// UpdateTransactions replaces the presigned transactions of a session
+	UpdateTransactions(nodeName string, transactions string) error
+	// Put writes a whole session, adding it or replacing the one with its node name
+	Put(session *Session) error
+	// Delete removes a session; removing a missing session is not an error
+	Delete(nodeName string) error
+	// Watch sends all sessions every time they change, checking every period
+	Watch(period time.Duration) <-chan []*Session
+}
+
+var onceSessions sync.Once
+var sessionStore SessionStore
+var ssMX sync.RWMutex // mx for sessionStore
+
+func initializeSessions() {
+	ssMX.Lock()
+	defer ssMX.Unlock()
+	if sessionStore != nil {
+		return // set with SetSessionStore
+	}
+	switch Config().SESSION_BACKEND {
+	case "dynamodb", "":
+		table := Config().SESSION_TABLE
+		if table == "" {
+			table = "YOUR-TABLE-NAME"
+		}
+		sessionStore = &dynamoSessions{table: table}
+	case "file":
+		sessionStore = &fileSessions{path: Config().SESSION_FILE}
+	case "memory":
+		sessionStore = NewMemorySessions(nil)
+	default:
+		panic(fmt.Errorf("unknown SESSION_BACKEND %s", Config().SESSION_BACKEND))
+	}
+}
+
+// Sessions returns the session store selected by SESSION_BACKEND
+func Sessions() SessionStore {
+	onceSessions.Do(initializeSessions)
+	ssMX.RLock()
+	defer ssMX.RUnlock()
+	return sessionStore
+}
+
+// SetSessionStore replaces the configured session store, e.g. with an in-memory one
+func SetSessionStore(store SessionStore) {
+	ssMX.Lock()
+	sessionStore = store
+	ssMX.Unlock()
+}
+
+func ScanSessions(out *[]*Session) error {
+	sessions, err := Sessions().List()
+	if err != nil {
+		return err
+	}
+	*out = sessions
+	return nil
+}
+
+func UpdateSessionActive(nodeName string, active bool) error {
+	return Sessions().UpdateState(nodeName, "active", active)
+}
+
+// UpdateSessionRuntime saves what the watcher knows about a session, restored on its next start
+func UpdateSessionRuntime(nodeName string, runtime *NodeRuntime) error {
+	if runtime == nil {
+		return Sessions().UpdateState(nodeName, "runtime", nil)
+	}
+	return Sessions().UpdateState(nodeName, "runtime", runtime)
+}
+
+// UpdateSessionDrain sets the maintenance window of a session, or removes it if drain is nil
+func UpdateSessionDrain(nodeName string, drain *Drain) error {
+	if drain == nil {
+		return Sessions().UpdateState(nodeName, "drain", nil)
+	}
+	return Sessions().UpdateState(nodeName, "drain", drain)
+}
+
+// UpdateSessionFence records that a session is fenced, or unfences it if fence is nil
+func UpdateSessionFence(nodeName string, fence *Fence) error {
+	if fence == nil {
+		return Sessions().UpdateState(nodeName, "fence", nil)
+	}
+	return Sessions().UpdateState(nodeName, "fence", fence)
+}
+
+// pollSessions implements Watch for stores without change notifications
+func pollSessions(store SessionStore, period time.Duration) <-chan []*Session {
+	changes := make(chan []*Session)
+	go func() {
+		last := ""
+		for {
+			sessions, err := store.List()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+			} else if raw, err := json.Marshal(watchedFields(sessions)); err == nil && string(raw) != last {
+				last = string(raw)
+				changes <- sessions
+			}
+			time.Sleep(period)
+		}
+	}()
+	return changes
+}
+
+// watchedSession is what Watch compares: the fields a reload reports as changed, and the drains and fences
+// set by the commands, but not the runtime state the watcher saves every STATE_SAVE_PERIOD_IN_SECONDS
+type watchedSession struct {
+	NodeName     string
+	GroupName    string
+	Collator     string
+	Priority     int
+	Proxy        string
+	AgentAddress string
+	Session      string
+	VrfKey       string
+	Transactions string
+	Drain        *Drain
+	Fence        *Fence
+}
+
+func watchedFields(sessions []*Session) []watchedSession {
+	watched := []watchedSession{}
+	for _, s := range sessions {
+		watched = append(watched, watchedSession{
+			NodeName:     s.NodeName,
+			GroupName:    s.GroupName,
+			Collator:     s.Collator,
+			Priority:     s.Priority,
+			Proxy:        s.Proxy,
+			AgentAddress: s.AgentAddress,
+			Session:      s.Session,
+			VrfKey:       s.VrfKey,
+			Transactions: s.Transactions,
+			Drain:        s.Drain,
+			Fence:        s.Fence,
+		})
+	}
+	return watched
+}
+
+// setSessionState applies UpdateState to a session held by the file and memory stores
+func setSessionState(session *Session, attribute string, value interface{}) error {
+	switch attribute {
+	case "drain":
+		drain, _ := value.(*Drain)
+		session.Drain = drain
+	case "fence":
+		fence, _ := value.(*Fence)
+		session.Fence = fence
+	case "active":
+		active, _ := value.(bool)
+		session.Active = active
+	case "runtime":
+		runtime, _ := value.(*NodeRuntime)
+		session.Runtime = runtime
+	default:
+		return fmt.Errorf("unknown session attribute %s", attribute)
+	}
+	return nil
+}
+
+func copySession(session *Session) *Session {
+	c := *session
+	c.Endpoints = append([]string(nil), session.Endpoints...)
+	c.Tags = append([]string(nil), session.Tags...)
+	if session.Drain != nil {
+		drain := *session.Drain
+		c.Drain = &drain
+	}
+	if session.Fence != nil {
+		fence := *session.Fence
+		c.Fence = &fence
+	}
+	if session.Runtime != nil {
+		runtime := *session.Runtime
+		if session.Runtime.LastFailover != nil {
+			lastFailover := *session.Runtime.LastFailover
+			runtime.LastFailover = &lastFailover
+		}
+		runtime.NotifiedAt = map[string]int{}
+		for key, at := range session.Runtime.NotifiedAt {
+			runtime.NotifiedAt[key] = at
+		}
+		c.Runtime = &runtime
+	}
+	return &c
+}
+
+/**
+dynamoSessions is the original store: one item per session keyed by nodeName.
+OfflineTxMaker writes the transactions of the same table
+**/
+type dynamoSessions struct {
+	table string
+}
+
+func (d *dynamoSessions) List() ([]*Session, error) {
+	params := dynamodb.ScanInput{
+		TableName: aws.String(d.table),
+	}
+	items, err := ScanItems(&params, 0, 1000)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*Session{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &sessions)
+	return sessions, err
+}
+
+func (d *dynamoSessions) Get(nodeName string) (*Session, error) {
+	out, err := DynamoDB().GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"nodeName": {
+				S: aws.String(nodeName),
+			},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	session := &Session{}
+	err = dynamodbattribute.UnmarshalMap(out.Item, session)
+	return session, err
+}
+
+func (d *dynamoSessions) UpdateState(nodeName string, attribute string, value interface{}) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"nodeName": {
+				S: aws.String(nodeName),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{"#a": aws.String(attribute), "#n": aws.String("nodeName")}, // active, runtime... may be reserved words
+		UpdateExpression:         aws.String("remove #a"),
+		ConditionExpression:      aws.String("attribute_exists(#n)"), // never brings a deleted session back as a partial row
+	}
+	if value != nil {
+		av, err := dynamodbattribute.Marshal(value)
+		if err != nil {
+			return err
+		}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":v": av}
+		input.UpdateExpression = aws.String("set #a = :v")
+	}
+	_, err := DynamoDB().UpdateItem(input)
+	if isConditionFailed(err) {
+		return fmt.Errorf("No session %s", nodeName)
+	}
+	return err
+}
+
+func (d *dynamoSessions) UpdateTransactions(nodeName string, transactions string) error {
+	return d.UpdateState(nodeName, "transactions", transactions)
+}
+
+func (d *dynamoSessions) Put(session *Session) error {
+	item, err := dynamodbattribute.MarshalMap(session)
+	if err != nil {
+		return err
+	}
+	_, err = DynamoDB().PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+	})
+	return err
+}
+
+func (d *dynamoSessions) Delete(nodeName string) error {
+	_, err := DynamoDB().DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"nodeName": {
+				S: aws.String(nodeName),
+			},
+		},
+	})
+	return err
+}
+
+func (d *dynamoSessions) Watch(period time.Duration) <-chan []*Session {
+	return pollSessions(d, period)
+}
+
+// fileSessions keeps a list of sessions in a JSON or YAML file (by extension) for self-hosted setups
+type fileSessions struct {
+	path string
+	mx   sync.Mutex
+}
+
+func (f *fileSessions) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(f.path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func (f *fileSessions) read() ([]*Session, error) {
+	raw, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return []*Session{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*Session{}
+	if err = yaml.Unmarshal(raw, &sessions); err != nil { // YAML is a superset of JSON
+		return nil, fmt.Errorf("%s: %v", f.path, err)
+	}
+	return sessions, nil
+}
+
+// write replaces the file atomically, so a crash never leaves half a session list
+func (f *fileSessions) write(sessions []*Session) error {
+	var raw []byte
+	var err error
+	if f.isYAML() {
+		raw, err = yaml.Marshal(sessions)
+	} else {
+		raw, err = json.MarshalIndent(sessions, "", "    ")
+	}
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *fileSessions) List() ([]*Session, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.read()
+}
+
+func (f *fileSessions) Get(nodeName string) (*Session, error) {
+	sessions, err := f.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.NodeName == nodeName {
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+// update applies change to one session and writes the file back
+func (f *fileSessions) update(nodeName string, change func(session *Session) error) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	sessions, err := f.read()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.NodeName == nodeName {
+			if err = change(session); err != nil {
+				return err
+			}
+			return f.write(sessions)
+		}
+	}
+	return fmt.Errorf("No session %s", nodeName)
+}
+
+func (f *fileSessions) UpdateState(nodeName string, attribute string, value interface{}) error {
+	return f.update(nodeName, func(session *Session) error {
+		return setSessionState(session, attribute, value)
+	})
+}
+
+func (f *fileSessions) UpdateTransactions(nodeName string, transactions string) error {
+	return f.update(nodeName, func(session *Session) error {
+		session.Transactions = transactions
+		return nil
+	})
+}
+
+func (f *fileSessions) Put(session *Session) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	sessions, err := f.read()
+	if err != nil {
+		return err
+	}
+	for i := range sessions {
+		if sessions[i].NodeName == session.NodeName {
+			sessions[i] = copySession(session)
+			return f.write(sessions)
+		}
+	}
+	return f.write(append(sessions, copySession(session)))
+}
+
+func (f *fileSessions) Delete(nodeName string) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	sessions, err := f.read()
+	if err != nil {
+		return err
+	}
+	kept := []*Session{}
+	for _, session := range sessions {
+		if session.NodeName != nodeName {
+			kept = append(kept, session)
+		}
+	}
+	if len(kept) == len(sessions) {
+		return nil
+	}
+	return f.write(kept)
+}
+
+func (f *fileSessions) Watch(period time.Duration) <-chan []*Session {
+	return pollSessions(f, period)
+}
+
+// memorySessions keeps sessions in memory only, for tests and dry runs
+type memorySessions struct {
+	sessions map[string]*Session
+	mx       sync.RWMutex
+}
+
+// NewMemorySessions returns an in-memory store holding copies of sessions
+func NewMemorySessions(sessions []*Session) SessionStore {
+	m := &memorySessions{sessions: map[string]*Session{}}
+	for _, session := range sessions {
+		m.sessions[session.NodeName] = copySession(session)
+	}
+	return m
+}
+
+func (m *memorySessions) List() ([]*Session, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	sessions := []*Session{}
+	for _, session := range m.sessions {
+		sessions = append(sessions, copySession(session))
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].NodeName < sessions[j].NodeName
+	})
+	return sessions, nil
+}
+
+func (m *memorySessions) Get(nodeName string) (*Session, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	if session, ok := m.sessions[nodeName]; ok {
+		return copySession(session), nil
+	}
+	return nil, nil
+}
+
+func (m *memorySessions) UpdateState(nodeName string, attribute string, value interface{}) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	session, ok := m.sessions[nodeName]
+	if !ok {
+		return fmt.Errorf("No session %s", nodeName)
+	}
+	return setSessionState(session, attribute, value)
+}
+
+func (m *memorySessions) UpdateTransactions(nodeName string, transactions string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	session, ok := m.sessions[nodeName]
+	if !ok {
+		return fmt.Errorf("No session %s", nodeName)
+	}
+	session.Transactions = transactions
+	return nil
+}
+
+func (m *memorySessions) Put(session *Session) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.sessions[session.NodeName] = copySession(session)
+	return nil
+}
+
+func (m *memorySessions) Delete(nodeName string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.sessions, nodeName)
+	return nil
+}
+
+func (m *memorySessions) Watch(period time.Duration) <-chan []*Session {
+	return pollSessions(m, period)
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemorySessions(t *testing.T) {
+	seed := &Session{NodeName: "node-b", GroupName: "moonriver", Session: "0x22", Endpoints: []string{"http://b:9933"}}
+	store := NewMemorySessions([]*Session{seed})
+	seed.Endpoints[0] = "changed by the caller"
+
+	if err := store.Put(&Session{NodeName: "node-a", GroupName: "moonriver", Session: "0x11"}); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].NodeName != "node-a" || sessions[1].NodeName != "node-b" {
+		t.Fatalf("listed %v", sessions)
+	}
+	if sessions[1].Endpoints[0] != "http://b:9933" {
+		t.Errorf("the store shares the endpoints of the seed: %v", sessions[1].Endpoints)
+	}
+	sessions[1].Endpoints[0] = "changed by a reader"
+	sessions[1].Priority = 7
+
+	session, err := store.Get("node-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Endpoints[0] != "http://b:9933" || session.Priority != 0 {
+		t.Errorf("the store shares its sessions with readers: %+v", session)
+	}
+	if missing, err := store.Get("node-z"); err != nil || missing != nil {
+		t.Errorf("missing session: %v (%v)", missing, err)
+	}
+
+	updates := []struct {
+		attribute string
+		value     interface{}
+		check     func(session *Session) bool
+	}{
+		{"active", true, func(s *Session) bool { return s.Active }},
+		{"fence", &Fence{At: 10, Reason: "test"}, func(s *Session) bool { return s.Fence != nil && s.Fence.Reason == "test" }},
+		{"fence", (*Fence)(nil), func(s *Session) bool { return s.Fence == nil }},
+		{"drain", &Drain{From: 20, Until: 30}, func(s *Session) bool { return s.Drain != nil && s.Drain.Until == 30 }},
+		{"runtime", &NodeRuntime{}, func(s *Session) bool { return s.Runtime != nil }},
+	}
+	for _, update := range updates {
+		if err = store.UpdateState("node-b", update.attribute, update.value); err != nil {
+			t.Fatalf("%s: %v", update.attribute, err)
+		}
+		if session, _ = store.Get("node-b"); !update.check(session) {
+			t.Errorf("%s not updated: %+v", update.attribute, session)
+		}
+	}
+	if err = store.UpdateState("node-b", "stopped", true); err == nil {
+		t.Error("updated an unknown attribute")
+	}
+	if err = store.UpdateState("node-z", "active", true); err == nil {
+		t.Error("updated a missing session")
+	}
+
+	if err = store.UpdateTransactions("node-b", `{"node-a":{"txs":["tx"],"nonce":1}}`); err != nil {
+		t.Fatal(err)
+	}
+	if session, _ = store.Get("node-b"); session.Transactions != `{"node-a":{"txs":["tx"],"nonce":1}}` {
+		t.Errorf("transactions %s", session.Transactions)
+	}
+	if err = store.UpdateTransactions("node-z", "{}"); err == nil {
+		t.Error("updated the transactions of a missing session")
+	}
+
+	if err = store.Delete("node-b"); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ = store.List(); len(sessions) != 1 || sessions[0].NodeName != "node-a" {
+		t.Errorf("after delete: %v", sessions)
+	}
+}
+
+func TestWatchIgnoresRuntimeState(t *testing.T) {
+	store := NewMemorySessions([]*Session{{NodeName: "node-a", GroupName: "moonriver", Session: "0x11"}})
+	changes := store.Watch(5 * time.Millisecond)
+	<-changes // the first listing
+
+	changed := func() bool {
+		select {
+		case <-changes:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+	steps := []struct {
+		name   string
+		update func() error
+		reload bool
+	}{
+		{"runtime", func() error { return store.UpdateState("node-a", "runtime", &NodeRuntime{}) }, false},
+		{"active", func() error { return store.UpdateState("node-a", "active", true) }, false},
+		{"fence", func() error { return store.UpdateState("node-a", "fence", &Fence{At: 10, Reason: "test"}) }, true},
+		{"drain", func() error { return store.UpdateState("node-a", "drain", &Drain{From: 20}) }, true},
+		{"transactions", func() error { return store.UpdateTransactions("node-a", "{}") }, true},
+		{"group", func() error {
+			session, _ := store.Get("node-a")
+			session.GroupName = "moonbase"
+			return store.Put(session)
+		}, true},
+		{"new session", func() error { return store.Put(&Session{NodeName: "node-b", GroupName: "moonbase"}) }, true},
+	}
+	for _, step := range steps {
+		if err := step.update(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if reloaded := changed(); reloaded != step.reload {
+			t.Errorf("%s: reload %v, expected %v", step.name, reloaded, step.reload)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/chilts/sid"
+)
+
+// TxWindow is the window of presigned transactions of one pair: from a node to another,
+// signed by one proxy of a collator, one transaction per nonce starting at Nonce
+type TxWindow struct {
+	Collator           string   `json:"collator"`
+	From               string   `json:"from"`
+	To                 string   `json:"to"`
+	Proxy              string   `json:"proxy"`
+	Nonce              int      `json:"nonce"` // nonce of TXs[0]
+	Count              int      `json:"count"`
+	TXs                []string `json:"txs,omitempty"`      // encrypted; not filled by Windows
+	SpecVersion        int      `json:"specVersion"`        // runtime the txs were signed against; 0 if unknown
+	TransactionVersion int      `json:"transactionVersion"` // 0 if unknown
+	CreatedAt          int64    `json:"createdAt"`          // unix seconds; 0 if unknown (older rows)
+	KeyVersion         int      `json:"keyVersion"`         // version of the keys the txs are encrypted with; 0 if unknown
+}
+
+// Key identifies the pair of the window
+func (w *TxWindow) Key() string {
+	return TxPairKey(w.Collator, w.From, w.To, w.Proxy)
+}
+
+// TxPairKey is the key of the window of (collator, from, to) signed by proxy
+func TxPairKey(collator string, from string, to string, proxy string) string {
+	return strings.Join([]string{collator, from, to, strings.ToLower(proxy)}, "/")
+}
+
+// PresignedTx is one presigned transaction and the metadata of its window (without TXs)
+type PresignedTx struct {
+	TX     string
+	Nonce  int
+	Window *TxWindow
+}
+
+// TxStore keeps the presigned transactions, keyed by (collator, from, to, proxy) and nonce
+type TxStore interface {
+	// Lookup returns the tx from session from to node to, signed by proxy, at nonce; nil if there is none
+	Lookup(collator string, from *Session, to string, proxy string, nonce int) (*PresignedTx, error)
+	// ReplaceWindow replaces the window of a pair as a whole; readers see either the old or the new window
+	ReplaceWindow(window *TxWindow) error
+	// Windows lists the windows of a collator from a node ("" for all), without their txs
+	Windows(collator string, from string) ([]*TxWindow, error)
+}
+
+var onceTxs sync.Once
+var txStore TxStore
+var tsMX sync.RWMutex // mx for txStore
+
+func initializeTxs() {
+	tsMX.Lock()
+	defer tsMX.Unlock()
+	if txStore != nil {
+		return // set with SetTxStore
+	}
+	switch Config().TX_BACKEND {
+	case "", "sessions":
+		txStore = &sessionTxs{}
+	case "dynamodb":
+		txStore = &dynamoTxs{table: Config().TX_TABLE}
+	case "file":
+		txStore = &fileTxs{path: Config().TX_FILE}
+	case "memory":
+		txStore = NewMemoryTxs()
+	default:
+		panic(fmt.Errorf("unknown TX_BACKEND %s", Config().TX_BACKEND))
+	}
+}
+
+// Txs returns the presigned tx store selected by TX_BACKEND
+func Txs() TxStore {
+	onceTxs.Do(initializeTxs)
+	tsMX.RLock()
+	defer tsMX.RUnlock()
+	return txStore
+}
+
+// SetTxStore replaces the configured tx store, e.g. with an in-memory one
+func SetTxStore(store TxStore) {
+	tsMX.Lock()
+	txStore = store
+	tsMX.Unlock()
+}
+
+// txAt picks the tx at nonce from a window, nil if the window does not cover it
+func txAt(window *TxWindow, nonce int) *PresignedTx {
+	if nonce < window.Nonce || nonce >= window.Nonce+len(window.TXs) {
+		return nil
+	}
+	meta := *window
+	meta.TXs = nil
+	return &PresignedTx{TX: window.TXs[nonce-window.Nonce], Nonce: nonce, Window: &meta}
+}
+
+// matchWindows filters windows by collator and from ("" for any), without their txs, sorted by key
+func matchWindows(windows []*TxWindow, collator string, from string) []*TxWindow {
+	matched := []*TxWindow{}
+	for _, window := range windows {
+		if (collator == "" || window.Collator == collator) && (from == "" || window.From == from) {
+			meta := *window
+			meta.TXs = nil
+			matched = append(matched, &meta)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Key() < matched[j].Key()
+	})
+	return matched
+}
+
+/**
+sessionTxs is the original layout: the Transactions blob of each session, written wholesale by OfflineTxMaker.
+Lookups use the session in memory, so they cost nothing; replacing a window rewrites the blob of its session
+**/
+type sessionTxs struct{}
+
+func (s *sessionTxs) Lookup(collator string, from *Session, to string, proxy string, nonce int) (*PresignedTx, error) {
+	txs, err := from.PresignedFor(to, proxy)
+	if err != nil || txs == nil {
+		return nil, err
+	}
+	return txAt(txs.Window(collator, from.NodeName, to, proxy), nonce), nil
+}
+
+func (s *sessionTxs) ReplaceWindow(window *TxWindow) error {
+	session, err := Sessions().Get(window.From)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("No session %s", window.From)
+	}
+	inventory, err := session.Inventory()
+	if err != nil {
+		return err
+	}
+	key := window.To
+	if !SameHex(window.Proxy, session.Proxy) {
+		key = window.To + "@" + window.Proxy
+	}
+	inventory[key] = PresignedTxs{
+		TXs:                window.TXs,
+		Nonce:              window.Nonce,
+		SpecVersion:        window.SpecVersion,
+		TransactionVersion: window.TransactionVersion,
+		Proxy:              window.Proxy,
+		CreatedAt:          window.CreatedAt,
+		KeyVersion:         window.KeyVersion,
+	}
+	raw, err := json.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+	return Sessions().UpdateTransactions(window.From, string(raw))
+}
+
+func (s *sessionTxs) Windows(collator string, from string) ([]*TxWindow, error) {
+	sessions, err := Sessions().List()
+	if err != nil {
+		return nil, err
+	}
+	windows := []*TxWindow{}
+	for _, session := range sessions {
+		c, err := CollatorOf(session)
+		if err != nil {
+			continue
+		}
+		sessionWindows, err := session.Windows(c.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", session.NodeName, err)
+		}
+		windows = append(windows, sessionWindows...)
+	}
+	return matchWindows(windows, collator, from), nil
+}
+
+/**
+dynamoTxs keeps one item per transaction (hash key pair, range key nonce) and a header per pair (nonce -1).
+Transactions of a window are written under a new generation and the header is switched to it with a
+conditional put, so replacing a window is atomic for readers; the previous generation is deleted afterwards.
+A lookup is two consistent reads: the header, then the tx of its generation
+**/
+type dynamoTxs struct {
+	table string
+}
+
+type txHeader struct {
+	Pair       string   `json:"pair"`
+	Nonce      int      `json:"nonce"` // always -1
+	Generation string   `json:"generation"`
+	Window     TxWindow `json:"window"` // without TXs
+}
+
+type txItem struct {
+	Pair  string `json:"pair"` // pair key#generation
+	Nonce int    `json:"nonce"`
+	TX    string `json:"tx"`
+}
+
+const headerNonce = -1
+
+func (d *dynamoTxs) itemKey(pair string, nonce int) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pair":  {S: aws.String(pair)},
+		"nonce": {N: aws.String(strconv.Itoa(nonce))},
+	}
+}
+
+func (d *dynamoTxs) header(pair string) (*txHeader, error) {
+	out, err := DynamoDB().GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            d.itemKey(pair, headerNonce),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	header := &txHeader{}
+	err = dynamodbattribute.UnmarshalMap(out.Item, header)
+	return header, err
+}
+
+func (d *dynamoTxs) Lookup(collator string, from *Session, to string, proxy string, nonce int) (*PresignedTx, error) {
+	header, err := d.header(TxPairKey(collator, from.NodeName, to, proxy))
+	if err != nil || header == nil {
+		return nil, err
+	}
+	window := header.Window
+	if nonce < window.Nonce || nonce >= window.Nonce+window.Count {
+		return nil, nil
+	}
+	out, err := DynamoDB().GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            d.itemKey(header.Pair+"#"+header.Generation, nonce),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	item := &txItem{}
+	if err = dynamodbattribute.UnmarshalMap(out.Item, item); err != nil {
+		return nil, err
+	}
+	return &PresignedTx{TX: item.TX, Nonce: nonce, Window: &window}, nil
+}
+
+func (d *dynamoTxs) ReplaceWindow(window *TxWindow) error {
+	pair := window.Key()
+	previous, err := d.header(pair)
+	if err != nil {
+		return err
+	}
+	generation := sid.Id()
+	puts := []*dynamodb.WriteRequest{}
+	for i, tx := range window.TXs {
+		item, err := dynamodbattribute.MarshalMap(txItem{Pair: pair + "#" + generation, Nonce: window.Nonce + i, TX: tx})
+		if err != nil {
+			return err
+		}
+		puts = append(puts, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	if err = d.batchWrite(puts); err != nil {
+		return err
+	}
+
+	meta := *window
+	meta.Count = len(window.TXs)
+	meta.TXs = nil
+	item, err := dynamodbattribute.MarshalMap(txHeader{Pair: pair, Nonce: headerNonce, Generation: generation, Window: meta})
+	if err != nil {
+		return err
+	}
+	put := &dynamodb.PutItemInput{
+		TableName:                aws.String(d.table),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#p)"),
+		ExpressionAttributeNames: map[string]*string{"#p": aws.String("pair")},
+	}
+	if previous != nil {
+		put.ConditionExpression = aws.String("#g = :g")
+		put.ExpressionAttributeNames = map[string]*string{"#g": aws.String("generation")}
+		put.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":g": {S: aws.String(previous.Generation)}}
+	}
+	if _, err = DynamoDB().PutItem(put); err != nil {
+		d.deleteGeneration(pair, generation, window.Nonce, len(window.TXs))
+		if isConditionFailed(err) {
+			return fmt.Errorf("window %s was replaced concurrently, try again", pair)
+		}
+		return err
+	}
+	if previous != nil {
+		d.deleteGeneration(pair, previous.Generation, previous.Window.Nonce, previous.Window.Count)
+	}
+	return nil
+}
+
+// deleteGeneration removes the txs of a generation no header points to; leftovers are harmless
+func (d *dynamoTxs) deleteGeneration(pair string, generation string, nonce int, count int) {
+	deletes := []*dynamodb.WriteRequest{}
+	for n := nonce; n < nonce+count; n++ {
+		deletes = append(deletes, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: d.itemKey(pair+"#"+generation, n)}})
+	}
+	if err := d.batchWrite(deletes); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+}
+
+// batchWrite writes requests 25 at a time, retrying the unprocessed ones
+func (d *dynamoTxs) batchWrite(requests []*dynamodb.WriteRequest) error {
+	for len(requests) > 0 {
+		n := Min(25, len(requests))
+		batch := map[string][]*dynamodb.WriteRequest{d.table: requests[:n]}
+		requests = requests[n:]
+		for len(batch) > 0 {
+			out, err := DynamoDB().BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: batch})
+			if err != nil {
+				return err
+			}
+			batch = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+func (d *dynamoTxs) Windows(collator string, from string) ([]*TxWindow, error) {
+	params := dynamodb.ScanInput{
+		TableName:                 aws.String(d.table),
+		FilterExpression:          aws.String("#n = :h"),
+		ExpressionAttributeNames:  map[string]*string{"#n": aws.String("nonce")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":h": {N: aws.String(strconv.Itoa(headerNonce))}},
+	}
+	items, err := ScanItems(&params, 0, 1000)
+	if err != nil {
+		return nil, err
+	}
+	headers := []*txHeader{}
+	if err = dynamodbattribute.UnmarshalListOfMaps(items, &headers); err != nil {
+		return nil, err
+	}
+	windows := []*TxWindow{}
+	for _, header := range headers {
+		window := header.Window
+		windows = append(windows, &window)
+	}
+	return matchWindows(windows, collator, from), nil
+}
+
+// fileTxs keeps all windows in one JSON file, rewritten under an exclusive flock
+type fileTxs struct {
+	path string
+}
+
+func (f *fileTxs) update(change func(windows map[string]*TxWindow) bool) (map[string]*TxWindow, error) {
+	windows := map[string]*TxWindow{} // pair key -> window
+	err := updateLocked(f.path, func(raw []byte) ([]byte, error) {
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &windows); err != nil {
+				return nil, fmt.Errorf("%s: %v", f.path, err)
+			}
+		}
+		if !change(windows) {
+			return nil, nil
+		}
+		return json.MarshalIndent(windows, "", "    ")
+	})
+	return windows, err
+}
+
+func (f *fileTxs) Lookup(collator string, from *Session, to string, proxy string, nonce int) (*PresignedTx, error) {
+	windows, err := f.update(func(windows map[string]*TxWindow) bool {
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	window, ok := windows[TxPairKey(collator, from.NodeName, to, proxy)]
+	if !ok {
+		return nil, nil
+	}
+	return txAt(window, nonce), nil
+}
+
+func (f *fileTxs) ReplaceWindow(window *TxWindow) error {
+	_, err := f.update(func(windows map[string]*TxWindow) bool {
+		w := *window
+		w.Count = len(window.TXs)
+		windows[window.Key()] = &w
+		return true
+	})
+	return err
+}
+
+func (f *fileTxs) Windows(collator string, from string) ([]*TxWindow, error) {
+	windows, err := f.update(func(windows map[string]*TxWindow) bool {
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	all := []*TxWindow{}
+	for _, window := range windows {
+		all = append(all, window)
+	}
+	return matchWindows(all, collator, from), nil
+}
+
+// memoryTxs keeps windows in memory only, for tests
+type memoryTxs struct {
+	windows map[string]*TxWindow
+	mx      sync.RWMutex
+}
+
+// NewMemoryTxs returns an empty in-memory tx store
+func NewMemoryTxs() TxStore {
+	return &memoryTxs{windows: map[string]*TxWindow{}}
+}
+
+func (m *memoryTxs) Lookup(collator string, from *Session, to string, proxy string, nonce int) (*PresignedTx, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	window, ok := m.windows[TxPairKey(collator, from.NodeName, to, proxy)]
+	if !ok {
+		return nil, nil
+	}
+	return txAt(window, nonce), nil
+}
+
+func (m *memoryTxs) ReplaceWindow(window *TxWindow) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	w := *window
+	w.TXs = append([]string{}, window.TXs...)
+	w.Count = len(w.TXs)
+	m.windows[window.Key()] = &w
+	return nil
+}
+
+func (m *memoryTxs) Windows(collator string, from string) ([]*TxWindow, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	all := []*TxWindow{}
+	for _, window := range m.windows {
+		all = append(all, window)
+	}
+	return matchWindows(all, collator, from), nil
+}
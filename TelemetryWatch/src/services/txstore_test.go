@@ -0,0 +1,215 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// txStores returns a fresh store of every backend that runs without AWS
+func txStores(t *testing.T) map[string]TxStore {
+	dir, err := ioutil.TempDir("", "txstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return map[string]TxStore{
+		"memory": NewMemoryTxs(),
+		"file":   &fileTxs{path: filepath.Join(dir, "txs.json")},
+	}
+}
+
+// windowOf makes a window of count txs from nonce, each tx naming its generation and nonce
+func windowOf(to string, generation int, nonce int, count int) *TxWindow {
+	window := &TxWindow{Collator: "main", From: "node-a", To: to, Proxy: "0xAA", Nonce: nonce, SpecVersion: 1201, KeyVersion: generation}
+	for i := 0; i < count; i++ {
+		window.TXs = append(window.TXs, fmt.Sprintf("gen%d-%d", generation, nonce+i))
+	}
+	return window
+}
+
+func TestTxStoreRoundTrip(t *testing.T) {
+	from := &Session{NodeName: "node-a"}
+	for name, store := range txStores(t) {
+		window := windowOf("node-b", 1, 5, 3)
+		if err := store.ReplaceWindow(window); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := store.ReplaceWindow(windowOf("node-c", 1, 9, 1)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		window.TXs[0] = "changed by the caller"
+
+		cases := []struct {
+			to    string
+			proxy string
+			nonce int
+			tx    string // "" if there is none
+		}{
+			{"node-b", "0xAA", 4, ""},
+			{"node-b", "0xAA", 5, "gen1-5"},
+			{"node-b", "0xaa", 7, "gen1-7"}, // proxies are compared without case
+			{"node-b", "0xAA", 8, ""},
+			{"node-c", "0xAA", 9, "gen1-9"},
+			{"node-b", "0xBB", 5, ""},
+			{"node-d", "0xAA", 5, ""},
+		}
+		for _, c := range cases {
+			tx, err := store.Lookup("main", from, c.to, c.proxy, c.nonce)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if c.tx == "" {
+				if tx != nil {
+					t.Errorf("%s: %s/%s nonce %d: found %s", name, c.to, c.proxy, c.nonce, tx.TX)
+				}
+				continue
+			}
+			if tx == nil || tx.TX != c.tx || tx.Nonce != c.nonce {
+				t.Errorf("%s: %s/%s nonce %d: %+v instead of %s", name, c.to, c.proxy, c.nonce, tx, c.tx)
+				continue
+			}
+			if tx.Window.TXs != nil || tx.Window.SpecVersion != 1201 || tx.Window.KeyVersion != 1 {
+				t.Errorf("%s: window of %s: %+v", name, c.tx, tx.Window)
+			}
+		}
+
+		windows, err := store.Windows("main", "node-a")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(windows) != 2 || windows[0].To != "node-b" || windows[0].Count != 3 || windows[0].TXs != nil || windows[1].To != "node-c" {
+			t.Errorf("%s: windows %+v", name, windows)
+		}
+		for _, filter := range [][2]string{{"other", ""}, {"", "node-b"}} {
+			if windows, err = store.Windows(filter[0], filter[1]); err != nil || len(windows) != 0 {
+				t.Errorf("%s: windows of %v: %v (%v)", name, filter, windows, err)
+			}
+		}
+
+		// a replaced window loses the nonces it no longer covers
+		if err = store.ReplaceWindow(windowOf("node-b", 2, 6, 1)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if tx, _ := store.Lookup("main", from, "node-b", "0xAA", 5); tx != nil {
+			t.Errorf("%s: nonce 5 survived the replacement: %s", name, tx.TX)
+		}
+		if tx, _ := store.Lookup("main", from, "node-b", "0xAA", 6); tx == nil || tx.TX != "gen2-6" || tx.Window.KeyVersion != 2 {
+			t.Errorf("%s: nonce 6 after the replacement: %+v", name, tx)
+		}
+	}
+}
+
+// readers running next to ReplaceWindow see either the old or the new window, never a mix of both
+func TestTxStoreReplaceWindowAtomic(t *testing.T) {
+	from := &Session{NodeName: "node-a"}
+	for name, store := range txStores(t) {
+		if err := store.ReplaceWindow(windowOf("node-b", 0, 10, 4)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		errs := make(chan error, 100)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+			for generation := 1; generation <= 50; generation++ {
+				// the generations alternate between 4 txs from nonce 10 and 2 txs from nonce 12
+				window := windowOf("node-b", generation, 10+2*(generation%2), 4-2*(generation%2))
+				if err := store.ReplaceWindow(window); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		for reader := 0; reader < 4; reader++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					for nonce := 10; nonce < 14; nonce++ {
+						tx, err := store.Lookup("main", from, "node-b", "0xAA", nonce)
+						if err != nil {
+							errs <- err
+							return
+						}
+						if tx == nil {
+							continue
+						}
+						expected := fmt.Sprintf("gen%d-%d", tx.Window.KeyVersion, nonce)
+						if tx.TX != expected || tx.Window.Count != 4-2*(tx.Window.KeyVersion%2) {
+							errs <- fmt.Errorf("read %s with the window of generation %d (count %d)", tx.TX, tx.Window.KeyVersion, tx.Window.Count)
+							return
+						}
+					}
+					windows, err := store.Windows("main", "node-a")
+					if err != nil || len(windows) != 1 || windows[0].Count != 4-2*(windows[0].KeyVersion%2) {
+						errs <- fmt.Errorf("windows %v (%v)", windows, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSessionTxsKeepsCreatedAtAndKeyVersion(t *testing.T) {
+	useConfig(Configuration{COLLATORS: []Collator{{Name: "main"}}})
+	proxy := "0x" + strings.Repeat("aa", 20)
+	// a Transactions blob as OfflineTxMaker writes it
+	blob := `{"node-b":{"txs":["tx5","tx6"],"nonce":5,"proxy":"` + proxy + `","specVersion":1201,"transactionVersion":2,"createdAt":1700000000,"keyVersion":3}}`
+	SetSessionStore(NewMemorySessions([]*Session{{NodeName: "node-a", GroupName: "moonriver", Proxy: proxy, Transactions: blob}}))
+	store := &sessionTxs{}
+
+	windows, err := store.Windows("", "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 {
+		t.Fatalf("%d windows", len(windows))
+	}
+	window := windows[0]
+	if window.CreatedAt != 1700000000 || window.KeyVersion != 3 || window.SpecVersion != 1201 || window.Count != 2 {
+		t.Errorf("window %+v", window)
+	}
+
+	// txs rewrap: same window, new txs, next key version
+	window.TXs = []string{"tx5'", "tx6'"}
+	window.KeyVersion++
+	if err = store.ReplaceWindow(window); err != nil {
+		t.Fatal(err)
+	}
+	windows, err = store.Windows("", "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 || windows[0].KeyVersion != 4 || windows[0].CreatedAt != 1700000000 {
+		t.Fatalf("replaced windows %+v", windows)
+	}
+	from, err := Sessions().Get("node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := store.Lookup("main", from, "node-b", proxy, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx == nil || tx.TX != "tx6'" || tx.Window.KeyVersion != 4 || tx.Window.CreatedAt != 1700000000 {
+		t.Errorf("looked up %+v", tx)
+	}
+}
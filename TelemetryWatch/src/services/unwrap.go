@@ -0,0 +1,121 @@
+package services
+
+import (
+	"fmt"
+	"movrfailover/txcrypt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+var onceKMS sync.Once
+var svcKMS *kms.KMS
+
+func initializeKMS() {
+	var sess *session.Session
+	var err error
+	if ENVIR == "dev" {
+		sess, err = session.NewSession(&aws.Config{
+			Region:      aws.String(AWSRegion()),
+			Credentials: credentials.NewSharedCredentials("", "movrfailover"),
+		})
+	} else {
+		sess, err = session.NewSession(&aws.Config{
+			Region: aws.String(AWSRegion()),
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		panic(err)
+	}
+	svcKMS = kms.New(sess)
+}
+
+func KMS() *kms.KMS {
+	onceKMS.Do(initializeKMS)
+	return svcKMS
+}
+
+/**
+Unwrap reverses the three layers OfflineTxMaker puts around every signed transaction and around the
+proxy private key (KMS, then AES under keyEnv, then AES under keyCaller) and returns the plain text.
+This requires the watcher to hold keyEnv and to be allowed to use the KMS key: presigned txs are validated
+unless SKIP_PRESIGNED_TX_VALIDATION is set, and are not submitted when they cannot be unwrapped
+**/
+func Unwrap(tx string) (string, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", err
+	}
+	plain, err := unwrapWith(keys, tx)
+	if err == nil {
+		return plain, nil
+	}
+	// txs made before the secret was rotated are wrapped under the previous keys
+	previous, perr := PreviousKeys()
+	if perr != nil || previous == nil || previous.KeyEnv == "" {
+		return "", err
+	}
+	if plain, perr = unwrapWith(previous, tx); perr != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+func unwrapWith(keys *SecretKey, tx string) (string, error) {
+	if keys.KeyEnv == "" {
+		return "", fmt.Errorf("keyEnv is not available in the secret")
+	}
+	return txcrypt.Unwrap(TxKMS(), TxKeys(keys), tx)
+}
+
+// TxKeys are the AES layer keys of the presigned txs in the secret
+func TxKeys(keys *SecretKey) txcrypt.Keys {
+	return txcrypt.Keys{Caller: keys.KeyCaller, Env: keys.KeyEnv}
+}
+
+// awsKMS is the KMS layer of the presigned txs, under KMS_KEY_ARN
+type awsKMS struct{}
+
+func (awsKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	result, err := KMS().Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(Config().KMS_KEY_ARN),
+		Plaintext: plaintext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.CiphertextBlob, nil
+}
+
+func (awsKMS) Decrypt(blob []byte) ([]byte, error) {
+	result, err := KMS().Decrypt(&kms.DecryptInput{
+		CiphertextBlob: blob,
+		KeyId:          aws.String(Config().KMS_KEY_ARN),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Plaintext, nil
+}
+
+var txKMS txcrypt.KMS = awsKMS{}
+var tkMX sync.RWMutex // mx for txKMS
+
+// TxKMS returns the KMS layer of the presigned txs: AWS KMS unless replaced with SetTxKMS
+func TxKMS() txcrypt.KMS {
+	tkMX.RLock()
+	defer tkMX.RUnlock()
+	return txKMS
+}
+
+// SetTxKMS replaces AWS KMS, e.g. with a txcrypt.LocalKMS
+func SetTxKMS(layer txcrypt.KMS) {
+	tkMX.Lock()
+	txKMS = layer
+	tkMX.Unlock()
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Verdict is what one watcher last concluded about the health of a node
+type Verdict struct {
+	NodeName  string `json:"nodeName"`
+	Watcher   string `json:"watcher"`
+	Healthy   bool   `json:"healthy"`
+	Reason    string `json:"reason"`
+	Imported  int    `json:"imported"`
+	Finalized int    `json:"finalized"`
+	At        int64  `json:"at"` // unix seconds
+}
+
+func (v *Verdict) String() string {
+	health := "healthy"
+	if !v.Healthy {
+		health = "unhealthy"
+	}
+	return fmt.Sprintf("%s says %s is %s (imported %d, finalized %d): %s", v.Watcher, v.NodeName, health, v.Imported, v.Finalized, v.Reason)
+}
+
+// VoteStore is shared by the watchers that vote on the health of the nodes
+type VoteStore interface {
+	// Publish replaces the verdict of its watcher on its node
+	Publish(verdict *Verdict) error
+	// Verdicts returns the verdicts of all watchers on a node given at or after since (unix seconds)
+	Verdicts(nodeName string, since int64) ([]*Verdict, error)
+}
+
+var onceVotes sync.Once
+var voteStore VoteStore
+
+func initializeVotes() {
+	switch Config().VOTE_BACKEND {
+	case "":
+		voteStore = nil // no quorum, this watcher decides alone
+	case "dynamodb":
+		voteStore = &dynamoVotes{table: Config().VOTE_TABLE}
+	case "file":
+		voteStore = &fileVotes{path: Config().VOTE_FILE}
+	case "memory":
+		voteStore = NewMemoryVotes()
+	default:
+		panic(fmt.Errorf("unknown VOTE_BACKEND %s", Config().VOTE_BACKEND))
+	}
+}
+
+// Votes returns the vote store selected by VOTE_BACKEND, nil if reassociations need no quorum
+func Votes() VoteStore {
+	onceVotes.Do(initializeVotes)
+	return voteStore
+}
+
+// dynamoVotes keeps one item per node and watcher (hash key nodeName, range key watcher)
+type dynamoVotes struct {
+	table string
+}
+
+func (d *dynamoVotes) Publish(verdict *Verdict) error {
+	item, err := dynamodbattribute.MarshalMap(verdict)
+	if err != nil {
+		return err
+	}
+	_, err = DynamoDB().PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+	})
+	return err
+}
+
+func (d *dynamoVotes) Verdicts(nodeName string, since int64) ([]*Verdict, error) {
+	out, err := DynamoDB().Query(&dynamodb.QueryInput{
+		TableName:                aws.String(d.table),
+		KeyConditionExpression:   aws.String("nodeName = :n"),
+		FilterExpression:         aws.String("#at >= :since"),
+		ExpressionAttributeNames: map[string]*string{"#at": aws.String("at")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":n":     {S: aws.String(nodeName)},
+			":since": {N: aws.String(strconv.FormatInt(since, 10))},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	verdicts := []*Verdict{}
+	err = dynamodbattribute.UnmarshalListOfMaps(out.Items, &verdicts)
+	return verdicts, err
+}
+
+// fileVotes keeps the verdicts in a JSON file shared by the watchers, under an exclusive flock
+type fileVotes struct {
+	path string
+}
+
+func (f *fileVotes) update(change func(verdicts map[string]*Verdict) bool) (map[string]*Verdict, error) {
+	verdicts := map[string]*Verdict{} // nodeName/watcher -> verdict
+	err := updateLocked(f.path, func(raw []byte) ([]byte, error) {
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &verdicts); err != nil {
+				return nil, fmt.Errorf("%s: %v", f.path, err)
+			}
+		}
+		if !change(verdicts) {
+			return nil, nil
+		}
+		return json.Marshal(verdicts)
+	})
+	return verdicts, err
+}
+
+func (f *fileVotes) Publish(verdict *Verdict) error {
+	_, err := f.update(func(verdicts map[string]*Verdict) bool {
+		verdicts[verdict.NodeName+"/"+verdict.Watcher] = verdict
+		return true
+	})
+	return err
+}
+
+func (f *fileVotes) Verdicts(nodeName string, since int64) ([]*Verdict, error) {
+	all, err := f.update(func(verdicts map[string]*Verdict) bool {
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return verdictsOf(all, nodeName, since), nil
+}
+
+// memoryVotes keeps the verdicts of the watchers of one process, for tests
+type memoryVotes struct {
+	verdicts map[string]*Verdict
+	mx       sync.Mutex
+}
+
+// NewMemoryVotes returns an in-memory vote store
+func NewMemoryVotes() VoteStore {
+	return &memoryVotes{verdicts: map[string]*Verdict{}}
+}
+
+func (m *memoryVotes) Publish(verdict *Verdict) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	v := *verdict
+	m.verdicts[verdict.NodeName+"/"+verdict.Watcher] = &v
+	return nil
+}
+
+func (m *memoryVotes) Verdicts(nodeName string, since int64) ([]*Verdict, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return verdictsOf(m.verdicts, nodeName, since), nil
+}
+
+func verdictsOf(all map[string]*Verdict, nodeName string, since int64) []*Verdict {
+	verdicts := []*Verdict{}
+	for _, verdict := range all {
+		if verdict.NodeName == nodeName && verdict.At >= since {
+			v := *verdict
+			verdicts = append(verdicts, &v)
+		}
+	}
+	sort.Slice(verdicts, func(i, j int) bool {
+		return verdicts[i].Watcher < verdicts[j].Watcher
+	})
+	return verdicts
+}
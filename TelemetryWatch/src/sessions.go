@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"movrfailover/services"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+var reportedProblems = map[string]bool{} // schema problems already reported
+var rpMX sync.Mutex                      // mx for reportedProblems
+
+/**
+checkSessions validates the sessions loaded at startup or by a reload. New problems are printed and alerted
+once; sessions with errors are left out, so one bad row never takes the other nodes' protection down
+**/
+func checkSessions(sessions []*services.Session) []*services.Session {
+	problems := services.ValidateSessions(sessions)
+	fresh := []string{}
+	current := map[string]bool{}
+	rpMX.Lock()
+	for _, problem := range problems {
+		current[problem.String()] = true
+		if !reportedProblems[problem.String()] {
+			fresh = append(fresh, problem.String())
+		}
+	}
+	reportedProblems = current
+	rpMX.Unlock()
+	if len(fresh) > 0 {
+		message := fmt.Sprintf(`Session schema problems: %s`, strings.Join(fresh, "; "))
+		fmt.Printf("%s\n", message)
+		notifyMe(message)
+	}
+
+	invalid := services.InvalidSessions(problems)
+	valid := []*services.Session{}
+	for _, session := range sessions {
+		if invalid[session.NodeName] {
+			fmt.Printf("Skipping invalid session %s\n", session.NodeName)
+			continue
+		}
+		valid = append(valid, session)
+	}
+	return valid
+}
+
+// sessionsCommand manages the session rows: `movrfailover sessions <command> [flags]`
+func sessionsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: movrfailover sessions list|add|priority|remove|import|export|validate|migrate [flags]")
+	}
+	subcommand, ok := sessionsCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown sessions command %s", args[0])
+	}
+	return subcommand(args[1:])
+}
+
+var sessionsCommands = map[string]func(args []string) error{
+	"add":      sessionsAddCommand,
+	"export":   sessionsExportCommand,
+	"import":   sessionsImportCommand,
+	"list":     sessionsListCommand,
+	"migrate":  sessionsMigrateCommand,
+	"priority": sessionsPriorityCommand,
+	"remove":   sessionsRemoveCommand,
+	"validate": sessionsValidateCommand,
+}
+
+// sessionsValidateCommand prints the schema problems of all sessions and fails if any is an error
+func sessionsValidateCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions validate", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	problems := services.ValidateSessions(sessions)
+	for _, problem := range problems {
+		fmt.Printf("%s\n", problem)
+	}
+	if invalid := services.InvalidSessions(problems); len(invalid) > 0 {
+		return fmt.Errorf("%d of %d sessions are invalid", len(invalid), len(sessions))
+	}
+	fmt.Printf("%d sessions are valid\n", len(sessions))
+	return nil
+}
+
+// sessionsMigrateCommand upgrades the stored rows to the current schema version
+func sessionsMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions migrate", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	dryRun := flags.Bool("dry-run", false, "print the migrations without writing the rows")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	migrated := 0
+	for _, session := range sessions {
+		applied, err := services.MigrateSession(session)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			continue
+		}
+		fmt.Printf("%s: %s\n", session.NodeName, strings.Join(applied, "; "))
+		if *dryRun {
+			continue
+		}
+		if err = services.Sessions().Put(session); err != nil {
+			return err
+		}
+		migrated++
+	}
+	fmt.Printf("Migrated %d sessions to schema %d\n", migrated, services.SessionSchema)
+	for _, problem := range services.ValidateSessions(sessions) {
+		fmt.Printf("%s\n", problem)
+	}
+	return nil
+}
+
+// sessionsListCommand prints the sessions with their stored runtime state and, unless -live=false, whether they are associated now
+func sessionsListCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions list", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	group := flags.String("group", "", "group name, empty for all")
+	live := flags.Bool("live", true, "ask the chain which sessions are associated")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	sessions, err := groupSessions(*group)
+	if err != nil {
+		return err
+	}
+	active := storedActive(sessions)
+	if *live {
+		active = liveActive(sessions)
+	}
+	windows, err := services.Txs().Windows("", "")
+	if err != nil {
+		fmt.Printf("Cannot list presigned txs: %v\n", err)
+	}
+	txs := map[string]int{}
+	for _, window := range windows {
+		txs[window.From] += window.Count
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "GROUP\tNODE\tPRIORITY\tCOLLATOR\tACTIVE\tSTATE\tIMPORTED\tFINALIZED\tSAVED\tHOLD\tTXS\tTAGS")
+	for _, session := range sessions {
+		state, imported, finalized, saved := "unknown", "-", "-", "-"
+		if session.Runtime != nil {
+			state = session.Runtime.State
+			imported = fmt.Sprintf("%d", session.Runtime.Imported)
+			finalized = fmt.Sprintf("%d", session.Runtime.Finalized)
+			saved = time.Unix(session.Runtime.SavedAt, 0).UTC().Format(time.RFC3339)
+		}
+		hold := "-"
+		if session.Fence != nil {
+			hold = "fenced: " + session.Fence.Reason
+		} else if session.Drain != nil {
+			hold = "drain " + describeDrain(session.Drain)
+		}
+		collator := session.Collator
+		if collator == "" {
+			collator = services.DefaultCollator
+		}
+		fmt.Fprintf(out, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", session.GroupName, session.NodeName, session.Priority,
+			collator, active[session.NodeName], state, imported, finalized, saved, hold, txs[session.NodeName], strings.Join(session.Tags, ","))
+	}
+	return out.Flush()
+}
+
+// groupSessions returns the sessions of a group (all if group is empty), by group then by decreasing priority
+func groupSessions(group string) ([]*services.Session, error) {
+	all := []*services.Session{}
+	if err := services.ScanSessions(&all); err != nil {
+		return nil, err
+	}
+	sessions := []*services.Session{}
+	for _, session := range all {
+		if group == "" || session.GroupName == group {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if sessions[i].GroupName != sessions[j].GroupName {
+			return sessions[i].GroupName < sessions[j].GroupName
+		}
+		return sessions[i].Priority > sessions[j].Priority
+	})
+	return sessions, nil
+}
+
+// storedActive is what the watcher recorded when it last reassociated, for when the chain cannot be asked
+func storedActive(sessions []*services.Session) map[string]string {
+	active := map[string]string{}
+	for _, session := range sessions {
+		active[session.NodeName] = fmt.Sprintf("%t (stored)", session.Active)
+	}
+	return active
+}
+
+// liveActive asks the chain which sessions are associated, falling back to the stored flags
+func liveActive(sessions []*services.Session) map[string]string {
+	whosActive, err := getActiveSessions(sessions)
+	if err != nil {
+		fmt.Printf("Cannot tell the associated sessions, showing the stored ones: %v\n", err)
+		return storedActive(sessions)
+	}
+	active := map[string]string{}
+	for _, session := range sessions {
+		act, ok := whosActive[session.Session]
+		active[session.NodeName] = fmt.Sprintf("%t", ok && act.Active)
+	}
+	return active
+}
+
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+/**
+sessionsAddCommand adds a node to a group. The row is validated against the schema together with the
+stored ones before it is written; the node gets no presigned transactions here, so a warning reminds to
+run OfflineTxMaker for it (without txs to and from it, the watcher cannot reassociate to or away from it)
+**/
+func sessionsAddCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions add", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	node := flags.String("node", "", "node name")
+	group := flags.String("group", "", "group name")
+	key := flags.String("session", "", "session key (0x hex)")
+	vrf := flags.String("vrf", "", "VRF key (0x hex), empty if none")
+	priority := flags.Int("priority", 0, "higher priority gets activated first")
+	collator := flags.String("collator", "", "collator name, empty for the default collator")
+	proxy := flags.String("proxy", "", "proxy account, empty for the first proxy of the collator")
+	agent := flags.String("agent", "", "http address of the node agent, empty if none")
+	endpoints := flags.String("endpoints", "", "comma separated RPC/WS endpoints of the node")
+	tags := flags.String("tags", "", "comma separated tags")
+	flags.Parse(args)
+	services.ENVIR = *envir
+	if *node == "" || *group == "" || *key == "" {
+		return errors.New("-node, -group and -session are required")
+	}
+
+	existing, err := services.Sessions().Get(*node)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("%s already exists in group %s", *node, existing.GroupName)
+	}
+	session := &services.Session{
+		NodeName:     *node,
+		GroupName:    *group,
+		Collator:     *collator,
+		Session:      *key,
+		Priority:     *priority,
+		Proxy:        *proxy,
+		VrfKey:       *vrf,
+		AgentAddress: *agent,
+	}
+	session.Endpoints = splitList(*endpoints)
+	session.Tags = splitList(*tags)
+	if _, err = services.MigrateSession(session); err != nil {
+		return err
+	}
+	if err = validateChanged([]*services.Session{session}, nil); err != nil {
+		return err
+	}
+	if err = services.Sessions().Put(session); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s to %s with priority %d\n", session.NodeName, session.GroupName, session.Priority)
+	warnMissingTxs(session)
+	return nil
+}
+
+/**
+validateChanged validates the stored sessions with the changed ones in place (and the removed ones left out),
+printing the problems of the changed sessions; it fails if any of them has an error
+**/
+func validateChanged(changed []*services.Session, removed map[string]bool) error {
+	stored := []*services.Session{}
+	if err := services.ScanSessions(&stored); err != nil {
+		return err
+	}
+	touched := map[string]bool{}
+	for _, session := range changed {
+		touched[session.NodeName] = true
+	}
+	sessions := []*services.Session{}
+	for _, session := range stored {
+		if !touched[session.NodeName] && !removed[session.NodeName] {
+			sessions = append(sessions, session)
+		}
+	}
+	problems := services.ValidateSessions(append(sessions, changed...))
+	for _, problem := range problems {
+		if touched[problem.NodeName] {
+			fmt.Printf("%s\n", problem)
+		}
+	}
+	invalid := services.InvalidSessions(problems)
+	for _, session := range changed {
+		if invalid[session.NodeName] {
+			return fmt.Errorf("%s is invalid, nothing written", session.NodeName)
+		}
+	}
+	return nil
+}
+
+// warnMissingTxs warns when there are no presigned txs to or from a node
+func warnMissingTxs(session *services.Session) {
+	collator, err := services.CollatorOf(session)
+	if err != nil {
+		return // reported by the validation
+	}
+	windows, err := services.Txs().Windows(collator.Name, "")
+	if err != nil {
+		fmt.Printf("Cannot check the presigned txs of %s: %v\n", session.NodeName, err)
+		return
+	}
+	from, to := 0, 0
+	for _, window := range windows {
+		if window.From == session.NodeName {
+			from++
+		}
+		if window.To == session.NodeName {
+			to++
+		}
+	}
+	if from == 0 || to == 0 {
+		fmt.Printf("Warning: %s has presigned txs to %d and from %d nodes, run OfflineTxMaker for group %s\n",
+			session.NodeName, from, to, session.GroupName)
+	}
+}
+
+// sessionsPriorityCommand changes the priority of a node
+func sessionsPriorityCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions priority", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	node := flags.String("node", "", "node name")
+	priority := flags.Int("priority", 0, "higher priority gets activated first")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	session, err := services.Sessions().Get(*node)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("no session %s", *node)
+	}
+	previous := session.Priority
+	session.Priority = *priority
+	if err = services.Sessions().Put(session); err != nil {
+		return err
+	}
+	fmt.Printf("Priority of %s changed from %d to %d\n", session.NodeName, previous, session.Priority)
+	return nil
+}
+
+// sessionsRemoveCommand removes a node; the associated node cannot be removed
+func sessionsRemoveCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions remove", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	node := flags.String("node", "", "node name")
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	session, err := services.Sessions().Get(*node)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("no session %s", *node)
+	}
+	if err = checkNotActive([]*services.Session{session}); err != nil {
+		return err
+	}
+	if !*yes {
+		fmt.Printf("Type yes to remove %s from %s: ", session.NodeName, session.GroupName)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			fmt.Println("Aborted")
+			return nil
+		}
+	}
+	if err = services.Sessions().Delete(session.NodeName); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s from %s\n", session.NodeName, session.GroupName)
+	return nil
+}
+
+// checkNotActive fails if one of the sessions is associated, or was when the chain cannot be asked
+func checkNotActive(sessions []*services.Session) error {
+	whosActive, err := getActiveSessions(sessions)
+	for _, session := range sessions {
+		if err != nil && session.Active {
+			return fmt.Errorf("%s was associated when last checked (%v), switch away from it first", session.NodeName, err)
+		}
+		if act, ok := whosActive[session.Session]; ok && act.Active {
+			return fmt.Errorf("%s is associated, switch away from it first", session.NodeName)
+		}
+	}
+	return nil
+}
+
+// sessionsFile is the YAML layout of `movrfailover sessions export` and `import`, one group per file
+type sessionsFile struct {
+	Group    string              `json:"group"`
+	Sessions []*services.Session `json:"sessions"`
+}
+
+// sessionsExportCommand writes the sessions of a group as YAML, without the state written by the watcher
+func sessionsExportCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions export", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	group := flags.String("group", "", "group name")
+	fileName := flags.String("file", "", "file to write, empty for stdout")
+	withTxs := flags.Bool("txs", false, "include the encrypted presigned transactions")
+	flags.Parse(args)
+	services.ENVIR = *envir
+	if *group == "" {
+		return errors.New("-group is required")
+	}
+
+	sessions, err := groupSessions(*group)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		session.Active = false
+		session.Runtime = nil
+		session.Drain = nil
+		session.Fence = nil
+		if !*withTxs {
+			session.Transactions = ""
+		}
+	}
+	raw, err := yaml.Marshal(&sessionsFile{Group: *group, Sessions: sessions})
+	if err != nil {
+		return err
+	}
+	if *fileName == "" {
+		_, err = os.Stdout.Write(raw)
+		return err
+	}
+	if err = ioutil.WriteFile(*fileName, raw, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d sessions of %s to %s\n", len(sessions), *group, *fileName)
+	return nil
+}
+
+/**
+sessionsImportCommand writes the sessions of a YAML file (as exported) to the store. Rows that exist keep
+their presigned transactions when the file has none, and always keep the state written by the watcher
+(runtime, drain, fence). With -prune, the nodes of the group missing from the file are removed, unless associated
+**/
+func sessionsImportCommand(args []string) error {
+	flags := flag.NewFlagSet("sessions import", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	group := flags.String("group", "", "group name, empty for the group of the file")
+	fileName := flags.String("file", "", "YAML file written by sessions export")
+	prune := flags.Bool("prune", false, "remove the nodes of the group missing from the file")
+	dryRun := flags.Bool("dry-run", false, "validate and print the changes without writing them")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	raw, err := ioutil.ReadFile(*fileName)
+	if err != nil {
+		return err
+	}
+	file := &sessionsFile{}
+	if err = yaml.Unmarshal(raw, file); err != nil {
+		return err
+	}
+	if *group == "" {
+		*group = file.Group
+	}
+	if *group == "" || (file.Group != "" && file.Group != *group) {
+		return fmt.Errorf("%s is for group %q, not %q", *fileName, file.Group, *group)
+	}
+
+	stored, err := groupSessions("")
+	if err != nil {
+		return err
+	}
+	byName := map[string]*services.Session{}
+	for _, session := range stored {
+		byName[session.NodeName] = session
+	}
+	imported := map[string]bool{}
+	for _, session := range file.Sessions {
+		if session.GroupName == "" {
+			session.GroupName = *group
+		}
+		if session.GroupName != *group {
+			return fmt.Errorf("%s is in group %s, not %s", session.NodeName, session.GroupName, *group)
+		}
+		imported[session.NodeName] = true
+		if old, ok := byName[session.NodeName]; ok {
+			if session.Transactions == "" {
+				session.Transactions = old.Transactions
+			}
+			session.Active = old.Active
+			session.Runtime = old.Runtime
+			session.Drain = old.Drain
+			session.Fence = old.Fence
+		}
+		if _, err = services.MigrateSession(session); err != nil {
+			return err
+		}
+	}
+	pruned := []*services.Session{}
+	removed := map[string]bool{}
+	if *prune {
+		for _, session := range stored {
+			if session.GroupName == *group && !imported[session.NodeName] {
+				pruned = append(pruned, session)
+				removed[session.NodeName] = true
+			}
+		}
+		if err = checkNotActive(pruned); err != nil {
+			return err
+		}
+	}
+	if err = validateChanged(file.Sessions, removed); err != nil {
+		return err
+	}
+
+	for _, session := range file.Sessions {
+		action := "add"
+		if _, ok := byName[session.NodeName]; ok {
+			action = "update"
+		}
+		fmt.Printf("%s %s (priority %d)\n", action, session.NodeName, session.Priority)
+	}
+	for _, session := range pruned {
+		fmt.Printf("remove %s\n", session.NodeName)
+	}
+	if *dryRun {
+		fmt.Println("Dry run, nothing written")
+		return nil
+	}
+	for _, session := range file.Sessions {
+		if err = services.Sessions().Put(session); err != nil {
+			return err
+		}
+	}
+	for _, session := range pruned {
+		if err = services.Sessions().Delete(session.NodeName); err != nil {
+			return err
+		}
+	}
+	for _, session := range file.Sessions {
+		if _, ok := byName[session.NodeName]; !ok {
+			warnMissingTxs(session)
+		}
+	}
+	fmt.Printf("Imported %d sessions into %s, removed %d\n", len(file.Sessions), *group, len(pruned))
+	return nil
+}
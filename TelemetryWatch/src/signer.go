@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+)
+
+/**
+proxySigner builds and signs the reassociation in-process with the proxy keys in SIGNER_KEYS,
+which are wrapped the same way OfflineTxMaker wraps proxyAccountPrivateKeyEncrypted.
+The call comes from the call builder of the group's current runtime, so unlike the presigned
+inventory it keeps working across runtime upgrades. Signed transactions are immortal
+**/
+type proxySigner struct{}
+
+// configureSigner enables the in-process signer if any signer key is configured
+func configureSigner() {
+	if len(services.Config().SIGNER_KEYS) > 0 {
+		signer = proxySigner{}
+	}
+}
+
+func (proxySigner) HasKey(proxy string) bool {
+	return wrappedSignerKey(proxy) != ""
+}
+
+func wrappedSignerKey(proxy string) string {
+	for p, key := range services.Config().SIGNER_KEYS {
+		if services.SameHex(p, proxy) {
+			return key
+		}
+	}
+	return ""
+}
+
+func (proxySigner) SignReassociation(sessAlert *services.Session, sessCandidate *services.Session, proxy string, nonce int, collator string) (string, error) {
+	wrapped := wrappedSignerKey(proxy)
+	if wrapped == "" {
+		return "", fmt.Errorf("No signer key for proxy %s", proxy)
+	}
+	privateKey, err := services.Unwrap(wrapped)
+	if err != nil {
+		return "", err
+	}
+	key, err := services.ParseEthereumKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	if !services.SameHex(key.Address, proxy) {
+		return "", fmt.Errorf("Signer key is for %s, not proxy %s", key.Address, proxy)
+	}
+
+	endpoint := services.RPCEndpoint(sessAlert.GroupName)
+	version := currentRuntime(sessAlert.GroupName)
+	if version == nil {
+		if version, err = services.GetRuntimeVersion(endpoint); err != nil {
+			return "", err
+		}
+	}
+	genesisHash, err := services.GetGenesisHash(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	builder := services.CallBuilderFor(version.SpecVersion)
+	fmt.Printf("Signing %s for spec %d\n", builder.Name(), version.SpecVersion)
+	call, err := builder.Reassociate(sessAlert, sessCandidate)
+	if err != nil {
+		return "", err
+	}
+	proxyCall, err := services.ProxyCallFor(collator, services.ProxyTypeAuthorMapping, call)
+	if err != nil {
+		return "", err
+	}
+	payload, err := services.SigningPayload(proxyCall, nonce, version, genesisHash)
+	if err != nil {
+		return "", err
+	}
+	return services.EncodeSignedExtrinsic(key.Address, key.Sign(payload), nonce, proxyCall)
+}
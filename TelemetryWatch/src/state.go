@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+	"sync"
+	"time"
+)
+
+var lastFailovers = map[string]*services.LastFailover{} // nodeName -> last reassociation away from it
+var lfMX sync.RWMutex                                   // mx for lastFailovers
+var startedAt = time.Now().Unix()
+
+/**
+The runtime state of every node (state machine, heights, remediation cooldown, alert chill timers,
+last failover) is saved on its session and restored on startup, so a watcher restarted in the middle
+of an incident carries on where it stopped instead of alerting, remediating or failing over again
+**/
+func restoreRuntime(sessions []*services.Session) {
+	restored := 0
+	savedAt := int64(0)
+	for _, session := range sessions {
+		runtime := session.Runtime
+		if runtime == nil {
+			continue
+		}
+		restored++
+		if runtime.SavedAt > savedAt {
+			savedAt = runtime.SavedAt
+		}
+		if runtime.State != "" && !isFenceState(runtime.State) { // fences are restored from the session by seedFences
+			nsMX.Lock()
+			nodeStates[session.NodeName] = &nodeState{State: runtime.State, Since: runtime.StateSince, Reason: runtime.StateReason}
+			nsMX.Unlock()
+		}
+		session.NotSynced = runtime.NotSynced
+
+		niMX.Lock()
+		nodeImported[session.NodeName] = services.Max(nodeImported[session.NodeName], runtime.Imported)
+		niMX.Unlock()
+		nfMX.Lock()
+		nodeFinalized[session.NodeName] = services.Max(nodeFinalized[session.NodeName], runtime.Finalized)
+		nfMX.Unlock()
+
+		rmMX.Lock()
+		remediatedAt[session.NodeName] = runtime.RemediatedAt
+		rmMX.Unlock()
+
+		naMX.Lock()
+		for key, at := range runtime.NotifiedAt {
+			notifiedAt[key] = services.Max(notifiedAt[key], at)
+		}
+		naMX.Unlock()
+
+		if last := runtime.LastFailover; last != nil {
+			lfMX.Lock()
+			lastFailovers[session.NodeName] = last
+			lfMX.Unlock()
+			restoreNonce(last)
+		}
+	}
+	if restored > 0 {
+		fmt.Printf("Restored runtime state of %d nodes, saved %s ago\n", restored, time.Since(time.Unix(savedAt, 0)).Round(time.Second))
+	}
+}
+
+// restoreNonce keeps the nonce of the latest failover of each collator
+func restoreNonce(last *services.LastFailover) {
+	latest := true
+	lfMX.RLock()
+	for _, other := range lastFailovers {
+		latest = latest && (other.Collator != last.Collator || other.At <= last.At)
+	}
+	lfMX.RUnlock()
+	if latest {
+		recordNonce(last.Collator, last.Nonce)
+	}
+}
+
+// persistRuntime saves the runtime state of every node every STATE_SAVE_PERIOD_IN_SECONDS, while we lead
+func persistRuntime() {
+	for {
+		time.Sleep(time.Duration(services.Config().STATE_SAVE_PERIOD_IN_SECONDS) * time.Second)
+		if !isLeader() {
+			continue // the leader's state is the one restored
+		}
+		for _, job := range currentJobs() {
+			for _, session := range job.sessions() {
+				saveRuntime(job.Collator, session)
+			}
+		}
+	}
+}
+
+func saveRuntime(collator *services.Collator, session *services.Session) {
+	if err := services.UpdateSessionRuntime(session.NodeName, runtimeOf(collator, session)); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+}
+
+func runtimeOf(collator *services.Collator, session *services.Session) *services.NodeRuntime {
+	state := nodeStateOf(session.NodeName)
+	runtime := &services.NodeRuntime{
+		State:       state.State,
+		StateSince:  state.Since,
+		StateReason: state.Reason,
+		NotSynced:   session.NotSynced,
+		NotifiedAt:  map[string]int{},
+		SavedAt:     time.Now().Unix(),
+	}
+	niMX.RLock()
+	runtime.Imported = nodeImported[session.NodeName]
+	niMX.RUnlock()
+	nfMX.RLock()
+	runtime.Finalized = nodeFinalized[session.NodeName]
+	nfMX.RUnlock()
+	rmMX.Lock()
+	runtime.RemediatedAt = remediatedAt[session.NodeName]
+	rmMX.Unlock()
+	naMX.RLock()
+	for _, key := range []string{collator.Name + "/" + session.GroupName, "drain/" + session.NodeName} { // job and drain alerts
+		if at, ok := notifiedAt[key]; ok {
+			runtime.NotifiedAt[key] = at
+		}
+	}
+	naMX.RUnlock()
+	lfMX.RLock()
+	runtime.LastFailover = lastFailovers[session.NodeName]
+	lfMX.RUnlock()
+	return runtime
+}
+
+// recordFailover saves a completed reassociation right away, not only on the next periodic save
+func recordFailover(collator *services.Collator, sessAlert *services.Session, sessCandidate *services.Session, nonce int) {
+	lfMX.Lock()
+	lastFailovers[sessAlert.NodeName] = &services.LastFailover{At: time.Now().Unix(), Collator: collator.Name, To: sessCandidate.NodeName, Nonce: nonce}
+	lfMX.Unlock()
+	if err := services.UpdateSessionActive(sessAlert.NodeName, false); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	if err := services.UpdateSessionActive(sessCandidate.NodeName, true); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	saveRuntime(collator, sessAlert)
+}
+
+/**
+waitFirstBlocks replaces the blind wait for the first blocks: the job starts as soon as telemetry
+reported a block of each of its nodes, and at the latest after FIRST_BLOCKS_WAIT_IN_SECONDS,
+so restored heights of a node that has caught up meanwhile never trigger a failover
+**/
+func waitFirstBlocks(job *failoverJob) bool {
+	deadline := time.Now().Add(time.Duration(services.Config().FIRST_BLOCKS_WAIT_IN_SECONDS) * time.Second)
+	for time.Now().Before(deadline) {
+		reported := true
+		niMX.RLock()
+		for _, session := range job.sessions() {
+			reported = reported && nodeReported[session.NodeName] >= startedAt
+		}
+		niMX.RUnlock()
+		if reported {
+			return true
+		}
+		if !job.sleep(time.Second) {
+			return false
+		}
+	}
+	return true
+}
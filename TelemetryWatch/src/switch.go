@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"movrfailover/services"
+)
+
+/**
+switchCommand moves the association of a collator in a group to a chosen node on purpose, e.g. before maintenance.
+It runs the same pipeline as failover(): readiness checks, tx selection or signing, validation,
+submission, verification and journaling. The target must be synced according to telemetry
+**/
+func switchCommand(args []string) error {
+	flags := flag.NewFlagSet("switch", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	group := flags.String("group", "", "group (network) of the nodes")
+	to := flags.String("to", "", "node name to associate")
+	collatorName := flags.String("collator", "", "collator name, needed if several collators run nodes in the group")
+	dryRun := flags.Bool("dry-run", false, "run all checks and show the transaction, but do not submit it")
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	flags.Parse(args)
+	services.ENVIR = *envir
+	if *group == "" || *to == "" {
+		return errors.New("Both --group and --to are required")
+	}
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	sessionGroups, jobs := loadJobs(sessions)
+	configureSigner()
+	job, sessCandidate, err := switchJob(jobs, *group, *to, *collatorName)
+	if err != nil {
+		return err
+	}
+	seedDrains(sessions)
+	seedFences(sessions)
+	if isDraining(sessCandidate) {
+		return fmt.Errorf("%s is draining for maintenance", sessCandidate.NodeName)
+	}
+	if isFenced(sessCandidate) {
+		return fmt.Errorf("%s is fenced, unfence it first", sessCandidate.NodeName)
+	}
+
+	// readiness: current runtime, chain view of the association, and telemetry view of the target
+	if err = loadRuntime(*group, sessionGroups[*group]); err != nil {
+		return err
+	}
+
+	whosActive, err := getActiveSessions(job.sessions())
+	if err != nil {
+		return err
+	}
+	if act, ok := whosActive[sessCandidate.Session]; ok && act.Active {
+		return fmt.Errorf("%s is already associated", sessCandidate.NodeName)
+	}
+	sessAlert := activeSession(job, whosActive)
+	if sessAlert == nil {
+		return fmt.Errorf("No session of %s is associated in %s", job.Collator.Name, *group)
+	}
+
+	health, err := waitForSync(job, sessCandidate, whosActive)
+	if err != nil {
+		return err
+	}
+
+	inc := &incident{
+		Detected: time.Now(),
+		Trigger:  fmt.Sprintf("manual switch from %s to %s", sessAlert.NodeName, sessCandidate.NodeName),
+		Health:   health,
+	}
+	confirm := func(choice *txChoice, entry *services.JournalEntry) bool {
+		fmt.Printf("Switch %s in %s from %s to %s\n", job.Collator.Name, *group, sessAlert.NodeName, sessCandidate.NodeName)
+		fmt.Printf("  proxy %s (rank %d), nonce %d\n", choice.Proxy, choice.Rank, choice.Nonce)
+		fmt.Printf("  %s\n", strings.Join(entry.Paths, ", "))
+		if entry.TxHash != "" {
+			fmt.Printf("  tx hash %s\n", entry.TxHash)
+		}
+		if *dryRun {
+			fmt.Println("Dry run, not submitting")
+			return false
+		}
+		if *yes {
+			return true
+		}
+		fmt.Print("Type yes to submit: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		return strings.TrimSpace(answer) == "yes"
+	}
+
+	reason := fmt.Sprintf("manual switch requested to %s", sessCandidate.NodeName)
+	err = switchOver(job, sessAlert, sessCandidate, whosActive, inc, reason, confirm)
+	sendQueuedAlerts()
+	if err == errNotConfirmed {
+		if !*dryRun {
+			fmt.Println("Aborted")
+		}
+		return nil
+	}
+	return err
+}
+
+// switchOver reassociates from the active sessAlert to sessCandidate through the failover pipeline
+func switchOver(job *failoverJob, sessAlert *services.Session, sessCandidate *services.Session, whosActive map[string]*WhosActive, inc *incident, reason string, confirm func(choice *txChoice, entry *services.JournalEntry) bool) error {
+	account, err := associatedAccount(job.Collator, sessAlert, whosActive)
+	if err != nil {
+		return err
+	}
+	nonces, err := getAccountNonces(job.Collator.ProxiesFor(sessAlert))
+	if err != nil {
+		return err
+	}
+	return reassociate(job.Collator, sessAlert, sessCandidate, job.sessions(), nonces, account, inc, reason, confirm)
+}
+
+// activeSession returns the session of the job that is currently associated, nil if none
+func activeSession(job *failoverJob, whosActive map[string]*WhosActive) *services.Session {
+	for _, session := range job.sessions() {
+		if act, ok := whosActive[session.Session]; ok && act.Active {
+			return session
+		}
+	}
+	return nil
+}
+
+// switchJob finds the failover job of the group that runs the target node
+func switchJob(jobs []*failoverJob, group string, to string, collatorName string) (*failoverJob, *services.Session, error) {
+	var found *failoverJob
+	var target *services.Session
+	for _, job := range jobs {
+		if job.GroupName != group || (collatorName != "" && job.Collator.Name != collatorName) {
+			continue
+		}
+		for _, session := range job.sessions() {
+			if session.NodeName != to {
+				continue
+			}
+			if found != nil {
+				return nil, nil, fmt.Errorf("%s runs for several collators in %s, use --collator", to, group)
+			}
+			found = job
+			target = session
+		}
+	}
+	if found == nil {
+		return nil, nil, fmt.Errorf("No session %s in group %s", to, group)
+	}
+	return found, target, nil
+}
+
+/**
+waitForSync follows telemetry until the target has reported blocks, then checks it against the
+same lag thresholds the delegator uses. Returns the health snapshot of the job's sessions
+**/
+func waitForSync(job *failoverJob, target *services.Session, whosActive map[string]*WhosActive) ([]services.NodeHealth, error) {
+	startTelemetry()
+
+	fmt.Printf("Waiting for telemetry of %s\n", target.NodeName)
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		time.Sleep(time.Duration(services.Config().BLOCK_CHECK_PERIOD_IN_SECONDS) * time.Second)
+		niMX.RLock()
+		nfMX.RLock()
+		reported := nodeImported[target.NodeName] > 0 && nodeFinalized[target.NodeName] > 0
+		maxImported := 0
+		maxFinalized := 0
+		for _, session := range job.sessions() {
+			maxImported = services.Max(nodeImported[session.NodeName], maxImported)
+			maxFinalized = services.Max(nodeFinalized[session.NodeName], maxFinalized)
+		}
+		imported := nodeImported[target.NodeName]
+		finalized := nodeFinalized[target.NodeName]
+		importedLag := maxImported - imported
+		finalizedLag := maxFinalized - finalized
+		health := healthSnapshot(job.sessions(), whosActive)
+		nfMX.RUnlock()
+		niMX.RUnlock()
+
+		if !reported {
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("%s did not report to telemetry", target.NodeName)
+			}
+			continue
+		}
+		if importedLag > services.Config().IMPORTED_REASSOCIATE_THRESHOLD || finalizedLag > services.Config().FINALIZED_REASSOCIATE_THRESHOLD {
+			return nil, fmt.Errorf("%s is not synced: imported %d behind, finalized %d behind", target.NodeName, importedLag, finalizedLag)
+		}
+		fmt.Printf("%s is synced at #%d (finalized #%d)\n", target.NodeName, imported, finalized)
+		return health, nil
+	}
+}
@@ -0,0 +1,56 @@
+package txcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+/**
+LocalKMS stands in for AWS KMS where the real key cannot be used (tests, tools, the golden vectors):
+AES-256-GCM under sha256(secret), with the 12 byte nonce in front of the sealed data.
+OfflineTxMaker/src/vectors.js implements the same stand-in
+**/
+type LocalKMS struct {
+	key []byte
+}
+
+// NewLocalKMS derives the key of the stand-in from a secret
+func NewLocalKMS(secret string) *LocalKMS {
+	key := sha256.Sum256([]byte(secret))
+	return &LocalKMS{key: key[:]}
+}
+
+func (l *LocalKMS) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(l.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (l *LocalKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	aesGCM, err := l.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aesGCM.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (l *LocalKMS) Decrypt(blob []byte) ([]byte, error) {
+	aesGCM, err := l.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) < aesGCM.NonceSize()+aesGCM.Overhead() {
+		return nil, fmt.Errorf("blob is too short")
+	}
+	return aesGCM.Open(nil, blob[:aesGCM.NonceSize()], blob[aesGCM.NonceSize():], nil)
+}
@@ -0,0 +1,141 @@
+/**
+Package txcrypt implements the encryption OfflineTxMaker puts around every signed transaction and around
+the proxy private keys, so that only the ReassociateMovr Lambda can unwrap them:
+
+	AES-256-CBC under keyCaller, then AES-256-CBC under keyEnv, then KMS
+
+Each AES layer uses a zero IV and, as key, the first 32 characters of base64(sha256(secret)), and writes hex.
+The hex of the second layer is handed to KMS as if it were base64 (kmsEncrypt does Buffer.from(hex, 'base64'))
+and kmsDecrypt returns the plain text as base64, which gives the hex back. The result is the base64 KMS blob
+**/
+package txcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// KMS is the outer layer: AWS KMS in production, LocalKMS for tests and tools
+type KMS interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(blob []byte) ([]byte, error)
+}
+
+// Keys are the secrets of the two AES layers
+type Keys struct {
+	Caller string // keyCaller, sent by the watcher to the Lambda
+	Env    string // keyEnv, in the environment of the Lambda
+}
+
+// layerKey mirrors crypto.createHash('sha256').update(String(secret)).digest('base64').substr(0, 32)
+func layerKey(secret string) []byte {
+	hash := sha256.Sum256([]byte(secret))
+	return []byte(base64.StdEncoding.EncodeToString(hash[:])[:32])
+}
+
+// EncryptLayer mirrors encrypt() in OfflineTxMaker: AES-256-CBC, zero IV, PKCS#7 padding, hex output
+func EncryptLayer(secret string, text string) (string, error) {
+	block, err := aes.NewCipher(layerKey(secret))
+	if err != nil {
+		return "", err
+	}
+	pad := aes.BlockSize - len(text)%aes.BlockSize
+	plain := append([]byte(text), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	enc := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(enc, plain)
+	return hex.EncodeToString(enc), nil
+}
+
+// DecryptLayer mirrors decrypt() in OfflineTxMaker
+func DecryptLayer(secret string, encrypted string) (string, error) {
+	enc, err := hex.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(layerKey(secret))
+	if err != nil {
+		return "", err
+	}
+	if len(enc) == 0 || len(enc)%aes.BlockSize != 0 {
+		return "", fmt.Errorf("ciphertext is not a multiple of the block size")
+	}
+	plain := make([]byte, len(enc))
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(plain, enc)
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return "", fmt.Errorf("bad padding; wrong key or corrupted ciphertext")
+	}
+	return string(plain[:len(plain)-pad]), nil
+}
+
+// Layers applies the two AES layers, returning what OfflineTxMaker hands to kmsEncrypt
+func Layers(keys Keys, plain string) (string, error) {
+	inner, err := EncryptLayer(keys.Caller, plain)
+	if err != nil {
+		return "", err
+	}
+	return EncryptLayer(keys.Env, inner)
+}
+
+// Wrap encrypts plain as OfflineTxMaker does, returning the base64 KMS blob
+func Wrap(kms KMS, keys Keys, plain string) (string, error) {
+	layered, err := Layers(keys, plain)
+	if err != nil {
+		return "", err
+	}
+	// Buffer.from(layered, 'base64'): the hex of whole AES blocks is a multiple of 4 characters, all base64
+	kmsPlain, err := base64.StdEncoding.DecodeString(layered)
+	if err != nil {
+		return "", err
+	}
+	blob, err := kms.Encrypt(kmsPlain)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(blob), nil
+}
+
+// Unwrap reverses Wrap: KMS, then AES under keyEnv, then AES under keyCaller
+func Unwrap(kms KMS, keys Keys, wrapped string) (string, error) {
+	layered, err := Peel(kms, wrapped)
+	if err != nil {
+		return "", err
+	}
+	inner, err := DecryptLayer(keys.Env, layered)
+	if err != nil {
+		return "", fmt.Errorf("keyEnv layer: %v", err)
+	}
+	plain, err := DecryptLayer(keys.Caller, inner)
+	if err != nil {
+		return "", fmt.Errorf("keyCaller layer: %v", err)
+	}
+	return plain, nil
+}
+
+// Peel removes the KMS layer only, returning the hex of the AES layers
+func Peel(kms KMS, wrapped string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return "", fmt.Errorf("not base64: %v", err)
+	}
+	kmsPlain, err := kms.Decrypt(blob)
+	if err != nil {
+		return "", fmt.Errorf("kms layer: %v", err)
+	}
+	// Plaintext.toString('base64') gives back the hex that was read as base64
+	return base64.StdEncoding.EncodeToString(kmsPlain), nil
+}
+
+// Rewrap decrypts with the old KMS and keys and encrypts again with the new ones, e.g. after a key rotation
+func Rewrap(oldKMS KMS, oldKeys Keys, newKMS KMS, newKeys Keys, wrapped string) (string, error) {
+	plain, err := Unwrap(oldKMS, oldKeys, wrapped)
+	if err != nil {
+		return "", err
+	}
+	return Wrap(newKMS, newKeys, plain)
+}
@@ -0,0 +1,178 @@
+package txcrypt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// vector is one golden vector of testdata/vectors.json, written by OfflineTxMaker/src/vectors.js with the functions of OfflineTxMaker
+type vector struct {
+	KeyCaller string `json:"keyCaller"`
+	KeyEnv    string `json:"keyEnv"`
+	Plain     string `json:"plain"`
+	Layered   string `json:"layered"` // after the two AES layers, deterministic (zero IV)
+	Wrapped   string `json:"wrapped"` // after the LocalKMS layer, random (GCM nonce)
+}
+
+func loadVectors(t *testing.T) (*LocalKMS, []vector) {
+	raw, err := ioutil.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture := struct {
+		LocalKMSSecret string   `json:"localKMSSecret"`
+		Vectors        []vector `json:"vectors"`
+	}{}
+	if err = json.Unmarshal(raw, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	if len(fixture.Vectors) == 0 {
+		t.Fatal("no vectors in testdata/vectors.json")
+	}
+	return NewLocalKMS(fixture.LocalKMSSecret), fixture.Vectors
+}
+
+// the AES layers must produce exactly what OfflineTxMaker produced, and its output must unwrap to the plain text
+func TestVectors(t *testing.T) {
+	kms, vectors := loadVectors(t)
+	for i, v := range vectors {
+		keys := Keys{Caller: v.KeyCaller, Env: v.KeyEnv}
+		layered, err := Layers(keys, v.Plain)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if layered != v.Layered {
+			t.Errorf("vector %d: layers are %s instead of %s", i, layered, v.Layered)
+		}
+		plain, err := Unwrap(kms, keys, v.Wrapped)
+		if err != nil {
+			t.Errorf("vector %d: cannot unwrap the JS output: %v", i, err)
+		} else if plain != v.Plain {
+			t.Errorf("vector %d: unwrapped %q instead of %q", i, plain, v.Plain)
+		}
+		peeled, err := Peel(kms, v.Wrapped)
+		if err != nil || peeled != v.Layered {
+			t.Errorf("vector %d: JS output peels to %s (%v)", i, peeled, err)
+		}
+	}
+}
+
+func TestWrapRoundTrip(t *testing.T) {
+	kms, vectors := loadVectors(t)
+	for i, v := range vectors {
+		keys := Keys{Caller: v.KeyCaller, Env: v.KeyEnv}
+		wrapped, err := Wrap(kms, keys, v.Plain)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if wrapped == v.Wrapped {
+			t.Errorf("vector %d: KMS layer is not randomized", i)
+		}
+		if peeled, err := Peel(kms, wrapped); err != nil || peeled != v.Layered {
+			t.Errorf("vector %d: wrapped does not peel to the layers (%v)", i, err)
+		}
+		if plain, err := Unwrap(kms, keys, wrapped); err != nil || plain != v.Plain {
+			t.Errorf("vector %d: wrapped unwraps to %q (%v)", i, plain, err)
+		}
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	kms, vectors := loadVectors(t)
+	rotated := NewLocalKMS("rotated-kms-secret")
+	for i, v := range vectors {
+		keys := Keys{Caller: v.KeyCaller, Env: v.KeyEnv}
+		newKeys := Keys{Caller: v.KeyCaller + "/rotated", Env: v.KeyEnv + "/rotated"}
+		rewrapped, err := Rewrap(kms, keys, rotated, newKeys, v.Wrapped)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+		if plain, err := Unwrap(rotated, newKeys, rewrapped); err != nil || plain != v.Plain {
+			t.Errorf("vector %d: rewrapped unwraps to %q (%v)", i, plain, err)
+		}
+		if _, err := Unwrap(rotated, keys, rewrapped); err == nil {
+			t.Errorf("vector %d: rewrapped unwraps with the old keys", i)
+		}
+		if _, err := Unwrap(kms, newKeys, rewrapped); err == nil {
+			t.Errorf("vector %d: rewrapped unwraps with the old KMS", i)
+		}
+	}
+}
+
+// flip changes one byte in the middle of a base64 blob
+func flip(t *testing.T, wrapped string) string {
+	blob, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[len(blob)/2] ^= 0x01
+	return base64.StdEncoding.EncodeToString(blob)
+}
+
+func TestUnwrapRejects(t *testing.T) {
+	kms, vectors := loadVectors(t)
+	v := vectors[0]
+	keys := Keys{Caller: v.KeyCaller, Env: v.KeyEnv}
+	// a second AES layer over the tampered hex, so only the AES layers can notice
+	tampered := []byte(v.Layered)
+	if tampered[len(tampered)-1] == '0' {
+		tampered[len(tampered)-1] = '1'
+	} else {
+		tampered[len(tampered)-1] = '0'
+	}
+	kmsPlain, err := base64.StdEncoding.DecodeString(string(tampered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := kms.Encrypt(kmsPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamperedLayers := base64.StdEncoding.EncodeToString(blob)
+
+	cases := []struct {
+		name    string
+		kms     KMS
+		keys    Keys
+		wrapped string
+	}{
+		{"tampered KMS blob", kms, keys, flip(t, v.Wrapped)},
+		{"truncated KMS blob", kms, keys, base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"not base64", kms, keys, "not base64!"},
+		{"tampered AES layers", kms, keys, tamperedLayers},
+		{"wrong KMS", NewLocalKMS("another kms secret"), keys, v.Wrapped},
+		{"wrong keyEnv", kms, Keys{Caller: v.KeyCaller, Env: v.KeyEnv + "x"}, v.Wrapped},
+		{"wrong keyCaller", kms, Keys{Caller: v.KeyCaller + "x", Env: v.KeyEnv}, v.Wrapped},
+		{"swapped keys", kms, Keys{Caller: v.KeyEnv, Env: v.KeyCaller}, v.Wrapped},
+	}
+	for _, c := range cases {
+		plain, err := Unwrap(c.kms, c.keys, c.wrapped)
+		if err == nil {
+			t.Errorf("%s: unwrapped to %q", c.name, plain)
+		}
+	}
+}
+
+func TestDecryptLayerRejects(t *testing.T) {
+	layer, err := EncryptLayer("secret", "exactly sixteen!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"empty":              "",
+		"not hex":            "zz",
+		"partial block":      layer[:len(layer)-2],
+		"last block dropped": layer[:len(layer)-32],
+	}
+	for name, encrypted := range cases {
+		if _, err := DecryptLayer("secret", encrypted); err == nil {
+			t.Errorf("%s: decrypted", name)
+		}
+	}
+	if _, err := DecryptLayer("another secret", layer); err == nil || !strings.Contains(err.Error(), "padding") {
+		t.Errorf("wrong secret: %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"movrfailover/services"
+	"movrfailover/txcrypt"
+	"time"
+)
+
+// txsCommand manages the presigned tx store: `movrfailover txs migrate|list|verify|decode|rewrap [flags]`
+func txsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: movrfailover txs migrate|list|verify|decode|rewrap [flags]")
+	}
+	switch args[0] {
+	case "migrate":
+		return txsMigrateCommand(args[1:])
+	case "list":
+		return txsListCommand(args[1:])
+	case "verify":
+		return txsVerifyCommand(args[1:])
+	case "decode":
+		return txsDecodeCommand(args[1:])
+	case "rewrap":
+		return txsRewrapCommand(args[1:])
+	}
+	return fmt.Errorf("unknown txs command %s", args[0])
+}
+
+/**
+txsMigrateCommand copies the Transactions blob of every session into the tx store selected by TX_BACKEND,
+one window per (collator, from, to, proxy). Each window is replaced as a whole, so the migration can be
+run again (e.g. after OfflineTxMaker rewrote the blobs) and the watcher can keep running meanwhile
+**/
+func txsMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("txs migrate", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	dryRun := flags.Bool("dry-run", false, "list the windows that would be written")
+	flags.Parse(args)
+	services.ENVIR = *envir
+	if services.Config().TX_BACKEND == "" || services.Config().TX_BACKEND == "sessions" {
+		return errors.New("TX_BACKEND is the Transactions blob already; set it to the store to migrate to")
+	}
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	migrated := 0
+	for _, session := range sessions {
+		collator, err := services.CollatorOf(session)
+		if err != nil {
+			fmt.Printf("Skipping session: %v\n", err)
+			continue
+		}
+		windows, err := session.Windows(collator.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", session.NodeName, err)
+		}
+		for _, window := range windows {
+			fmt.Printf("%s: %d txs from nonce %d, spec %d/tx %d\n", window.Key(), len(window.TXs), window.Nonce, window.SpecVersion, window.TransactionVersion)
+			if *dryRun {
+				continue
+			}
+			if err = services.Txs().ReplaceWindow(window); err != nil {
+				return fmt.Errorf("%s: %v", window.Key(), err)
+			}
+			migrated++
+		}
+	}
+	fmt.Printf("Migrated %d windows to %s\n", migrated, services.Config().TX_BACKEND)
+	return nil
+}
+
+// txsListCommand prints the windows of the tx store, without the txs
+func txsListCommand(args []string) error {
+	flags := flag.NewFlagSet("txs list", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	collator := flags.String("collator", "", "collator name, empty for all")
+	from := flags.String("from", "", "node the txs move the association from, empty for all")
+	flags.Parse(args)
+	services.ENVIR = *envir
+
+	windows, err := services.Txs().Windows(*collator, *from)
+	if err != nil {
+		return err
+	}
+	for _, window := range windows {
+		created := "unknown"
+		if window.CreatedAt > 0 {
+			created = time.Unix(window.CreatedAt, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%s: nonces %d-%d, spec %d/tx %d, created %s, key version %d\n", window.Key(),
+			window.Nonce, window.Nonce+window.Count-1, window.SpecVersion, window.TransactionVersion, created, window.KeyVersion)
+	}
+	return nil
+}
+
+// localKMSFlag lets the tx commands use txcrypt.LocalKMS instead of AWS KMS, for txs wrapped by a test setup
+func localKMSFlag(flags *flag.FlagSet) func() {
+	secret := flags.String("local-kms", "", "secret of a local KMS stand-in, empty for AWS KMS")
+	return func() {
+		if *secret != "" {
+			services.SetTxKMS(txcrypt.NewLocalKMS(*secret))
+		}
+	}
+}
+
+// windowTxs returns a window of the tx store with its txs, which Windows leaves out
+func windowTxs(window *services.TxWindow) (*services.TxWindow, error) {
+	from, err := services.Sessions().Get(window.From)
+	if err != nil {
+		return nil, err
+	}
+	if from == nil {
+		return nil, fmt.Errorf("no session %s", window.From)
+	}
+	full := *window
+	full.TXs = []string{}
+	for nonce := window.Nonce; nonce < window.Nonce+window.Count; nonce++ {
+		tx, err := services.Txs().Lookup(window.Collator, from, window.To, window.Proxy, nonce)
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			return nil, fmt.Errorf("%s has no tx at nonce %d", window.Key(), nonce)
+		}
+		full.TXs = append(full.TXs, tx.TX)
+	}
+	return &full, nil
+}
+
+// checkWrapped unwraps a presigned tx and checks that it is a proxy call signed by the proxy of its window at nonce
+func checkWrapped(window *services.TxWindow, tx string, nonce int) (*services.Extrinsic, error) {
+	plain, err := services.Unwrap(tx)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := services.DecodeExtrinsic(plain)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode extrinsic: %v", err)
+	}
+	if !services.SameHex(ext.Signer, window.Proxy) {
+		return ext, fmt.Errorf("signed by %s instead of proxy %s", ext.Signer, window.Proxy)
+	}
+	if ext.Nonce != nonce {
+		return ext, fmt.Errorf("signed for nonce %d instead of %d", ext.Nonce, nonce)
+	}
+	if _, err = ext.Call.DecodeProxy(); err != nil {
+		return ext, err
+	}
+	return ext, nil
+}
+
+/**
+txsVerifyCommand unwraps every presigned tx of the store (KMS, keyEnv, keyCaller, falling back to the previous
+keys of the secret) and decodes it, reporting the txs that cannot be unwrapped or are not signed by the proxy
+of their window at their nonce. It needs keyEnv in the secret and the right to use the KMS key
+**/
+func txsVerifyCommand(args []string) error {
+	flags := flag.NewFlagSet("txs verify", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	collator := flags.String("collator", "", "collator name, empty for all")
+	from := flags.String("from", "", "node the txs move the association from, empty for all")
+	useLocalKMS := localKMSFlag(flags)
+	flags.Parse(args)
+	services.ENVIR = *envir
+	useLocalKMS()
+
+	windows, err := services.Txs().Windows(*collator, *from)
+	if err != nil {
+		return err
+	}
+	bad := 0
+	for _, window := range windows {
+		full, err := windowTxs(window)
+		if err != nil {
+			fmt.Printf("%s: %v\n", window.Key(), err)
+			bad++
+			continue
+		}
+		ok := 0
+		for i, tx := range full.TXs {
+			if _, err = checkWrapped(window, tx, window.Nonce+i); err != nil {
+				fmt.Printf("%s nonce %d: %v\n", window.Key(), window.Nonce+i, err)
+				bad++
+				continue
+			}
+			ok++
+		}
+		fmt.Printf("%s: %d of %d txs verified\n", window.Key(), ok, len(full.TXs))
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d problems in %d windows", bad, len(windows))
+	}
+	return nil
+}
+
+// txsDecodeCommand prints one presigned tx decoded; the plain signed tx only with -raw, since anyone can submit it
+func txsDecodeCommand(args []string) error {
+	flags := flag.NewFlagSet("txs decode", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	collator := flags.String("collator", "", "collator name, empty for the collator of the from session")
+	from := flags.String("from", "", "node the tx moves the association from")
+	to := flags.String("to", "", "node the tx moves the association to")
+	proxy := flags.String("proxy", "", "proxy account, empty for the primary proxy of the collator")
+	nonce := flags.Int("nonce", 0, "nonce of the tx")
+	raw := flags.Bool("raw", false, "also print the plain signed tx")
+	useLocalKMS := localKMSFlag(flags)
+	flags.Parse(args)
+	services.ENVIR = *envir
+	useLocalKMS()
+
+	session, err := services.Sessions().Get(*from)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("no session %s", *from)
+	}
+	if *collator == "" || *proxy == "" {
+		owner, err := services.CollatorOf(session)
+		if err != nil {
+			return err
+		}
+		if *collator == "" {
+			*collator = owner.Name
+		}
+		if *proxy == "" {
+			*proxy = owner.ProxiesFor(session)[0]
+		}
+	}
+	tx, err := services.Txs().Lookup(*collator, session, *to, *proxy, *nonce)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		return fmt.Errorf("no presigned tx from %s to %s at nonce %d", *from, *to, *nonce)
+	}
+	ext, err := checkWrapped(tx.Window, tx.TX, *nonce)
+	if ext == nil {
+		return err
+	}
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	fmt.Printf("window: %s, spec %d/tx %d, key version %d\n", tx.Window.Key(), tx.Window.SpecVersion, tx.Window.TransactionVersion, tx.Window.KeyVersion)
+	fmt.Printf("signer: %s\nnonce: %d\ntip: %d\ncall: %d.%d\n", ext.Signer, ext.Nonce, ext.Tip, ext.Call.Pallet, ext.Call.Method)
+	if proxyCall, err := ext.Call.DecodeProxy(); err == nil {
+		fmt.Printf("proxy: real %s, type %d, call %d.%d\n", proxyCall.Real, proxyCall.ForceProxyType, proxyCall.Call.Pallet, proxyCall.Call.Method)
+	}
+	if *raw {
+		plain, _ := services.Unwrap(tx.TX)
+		fmt.Printf("tx: %s\n", plain)
+	}
+	return nil
+}
+
+/**
+txsRewrapCommand re-encrypts the presigned txs after the keys in the secret were rotated: the txs that only
+unwrap with the previous keys are wrapped again under the current ones and their windows replaced, with the
+key version bumped. Windows that already unwrap with the current keys are left alone, so it can be rerun
+**/
+func txsRewrapCommand(args []string) error {
+	flags := flag.NewFlagSet("txs rewrap", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	collator := flags.String("collator", "", "collator name, empty for all")
+	from := flags.String("from", "", "node the txs move the association from, empty for all")
+	dryRun := flags.Bool("dry-run", false, "list the windows that would be rewrapped")
+	useLocalKMS := localKMSFlag(flags)
+	flags.Parse(args)
+	services.ENVIR = *envir
+	useLocalKMS()
+
+	current, err := services.Keys()
+	if err != nil {
+		return err
+	}
+	previous, err := services.PreviousKeys()
+	if err != nil {
+		return err
+	}
+	if previous == nil || previous.KeyEnv == "" || current.KeyEnv == "" {
+		return errors.New("rewrapping needs keyEnv in the current and the previous version of the secret")
+	}
+	windows, err := services.Txs().Windows(*collator, *from)
+	if err != nil {
+		return err
+	}
+	rewrapped := 0
+	for _, window := range windows {
+		full, err := windowTxs(window)
+		if err != nil {
+			return fmt.Errorf("%s: %v", window.Key(), err)
+		}
+		if len(full.TXs) == 0 {
+			continue
+		}
+		if _, err = txcrypt.Unwrap(services.TxKMS(), services.TxKeys(current), full.TXs[0]); err == nil {
+			continue // already under the current keys
+		}
+		for i, tx := range full.TXs {
+			full.TXs[i], err = txcrypt.Rewrap(services.TxKMS(), services.TxKeys(previous), services.TxKMS(), services.TxKeys(current), tx)
+			if err != nil {
+				return fmt.Errorf("%s nonce %d: %v", window.Key(), window.Nonce+i, err)
+			}
+		}
+		full.KeyVersion++
+		fmt.Printf("%s: %d txs rewrapped, key version %d\n", window.Key(), len(full.TXs), full.KeyVersion)
+		if *dryRun {
+			continue
+		}
+		if err = services.Txs().ReplaceWindow(full); err != nil {
+			return fmt.Errorf("%s: %v", window.Key(), err)
+		}
+		rewrapped++
+	}
+	fmt.Printf("Rewrapped %d windows\n", rewrapped)
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"movrfailover/services"
+)
+
+/**
+UpgradePlan describes a rolling client upgrade of a group, read from a YAML or JSON file.
+Upgrade is run on a node while it is drained; the node must then be synced on telemetry
+(and pass Check, if set) within HealthyTimeoutInSeconds. Rollback, if set, is run on upgraded nodes
+when a later step fails; a switch is only undone if its former node was rolled back and is synced again
+**/
+type UpgradePlan struct {
+	Upgrade                 services.ActionConfig  `json:"upgrade"`
+	Check                   *services.ActionConfig `json:"check"`
+	Rollback                *services.ActionConfig `json:"rollback"`
+	HealthyTimeoutInSeconds int                    `json:"healthyTimeoutInSeconds"`
+	SwitchBack              bool                   `json:"switchBack"` // return the association to the former primary
+}
+
+// healthyChecks is how many consecutive block checks an upgraded node must pass
+const healthyChecks = 3
+
+/**
+upgradeCommand upgrades the standby, waits until it is healthy, switches to it, upgrades the former primary,
+waits until it is healthy and optionally switches back. Every step is journaled as one workflow
+**/
+func upgradeCommand(args []string) error {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	envir := flags.String("env", "prod", "environment (dev or prod)")
+	group := flags.String("group", "", "group (network) to upgrade")
+	collatorName := flags.String("collator", "", "collator name, needed if several collators run nodes in the group")
+	planFile := flags.String("plan", "", "upgrade plan (YAML or JSON)")
+	switchBack := flags.Bool("switch-back", false, "switch back to the former primary at the end (overrides the plan)")
+	dryRun := flags.Bool("dry-run", false, "show the steps without running them")
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	flags.Parse(args)
+	services.ENVIR = *envir
+	if *group == "" || *planFile == "" {
+		return errors.New("Both --group and --plan are required")
+	}
+	plan, err := loadUpgradePlan(*planFile)
+	if err != nil {
+		return err
+	}
+	plan.SwitchBack = plan.SwitchBack || *switchBack
+
+	sessions := []*services.Session{}
+	if err := services.ScanSessions(&sessions); err != nil {
+		return err
+	}
+	sessionGroups, jobs := loadJobs(sessions)
+	configureSigner()
+	seedDrains(sessions)
+	seedFences(sessions)
+	job, err := groupJob(jobs, *group, *collatorName)
+	if err != nil {
+		return err
+	}
+	if err = loadRuntime(*group, sessionGroups[*group]); err != nil {
+		return err
+	}
+
+	whosActive, err := getActiveSessions(job.sessions())
+	if err != nil {
+		return err
+	}
+	primary := activeSession(job, whosActive)
+	if primary == nil {
+		return fmt.Errorf("No session of %s is associated in %s", job.Collator.Name, *group)
+	}
+	standby := standbyOf(job, primary)
+	if standby == nil {
+		return fmt.Errorf("No standby for %s in %s", primary.NodeName, *group)
+	}
+	if _, err = waitForSync(job, standby, whosActive); err != nil {
+		return err
+	}
+
+	w, err := upgradeWorkflow(job, plan, primary, standby)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Upgrade %s of %s in %s:\n", w.ID, job.Collator.Name, *group)
+	for i, step := range w.Steps {
+		fmt.Printf("  %d. %s %s\n", i+1, step.Name, step.Node)
+	}
+	if *dryRun {
+		fmt.Println("Dry run, not upgrading")
+		return nil
+	}
+	if !*yes {
+		fmt.Print("Type yes to start: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			fmt.Println("Aborted")
+			return nil
+		}
+	}
+	err = w.run()
+	sendQueuedAlerts()
+	return err
+}
+
+func loadUpgradePlan(fileName string) (*UpgradePlan, error) {
+	raw, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	plan := &UpgradePlan{}
+	if err = yaml.Unmarshal(raw, plan); err != nil {
+		return nil, err
+	}
+	if plan.HealthyTimeoutInSeconds <= 0 {
+		plan.HealthyTimeoutInSeconds = 900
+	}
+	return plan, nil
+}
+
+// groupJob returns the failover job of a group, naming the collator if several run nodes in it
+func groupJob(jobs []*failoverJob, group string, collatorName string) (*failoverJob, error) {
+	var found *failoverJob
+	for _, job := range jobs {
+		if job.GroupName != group || (collatorName != "" && job.Collator.Name != collatorName) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("Several collators run nodes in %s, use --collator", group)
+		}
+		found = job
+	}
+	if found == nil {
+		return nil, fmt.Errorf("No sessions in group %s", group)
+	}
+	return found, nil
+}
+
+// standbyOf returns the session with the highest priority that is neither the primary, draining nor fenced
+func standbyOf(job *failoverJob, primary *services.Session) *services.Session {
+	candidates := []*services.Session{}
+	for _, session := range job.sessions() {
+		if session != primary && !isDraining(session) && !isFenced(session) {
+			candidates = append(candidates, session)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	}) // higher priority gets activated first
+	return candidates[0]
+}
+
+func upgradeWorkflow(job *failoverJob, plan *UpgradePlan, primary *services.Session, standby *services.Session) (*workflow, error) {
+	upgrade, err := newAction(plan.Upgrade)
+	if err != nil {
+		return nil, err
+	}
+	var check, rollback Action
+	if plan.Check != nil {
+		if check, err = newAction(*plan.Check); err != nil {
+			return nil, err
+		}
+	}
+	if plan.Rollback != nil {
+		if rollback, err = newAction(*plan.Rollback); err != nil {
+			return nil, err
+		}
+	}
+	timeout := time.Duration(plan.HealthyTimeoutInSeconds) * time.Second
+
+	w := newWorkflow("upgrade", job.Collator, job.GroupName)
+	upgradeNode := func(session *services.Session) {
+		w.add(&workflowStep{
+			Name: "drain",
+			Node: session.NodeName,
+			Run: func() error {
+				return services.UpdateSessionDrain(session.NodeName, &services.Drain{From: time.Now().Unix()})
+			},
+			Rollback: func() error {
+				return services.UpdateSessionDrain(session.NodeName, nil)
+			},
+		})
+		step := &workflowStep{
+			Name: "upgrade",
+			Node: session.NodeName,
+			Run: func() error {
+				return upgrade.Run(session)
+			},
+		}
+		if rollback != nil {
+			step.Rollback = func() error {
+				return rollback.Run(session)
+			}
+		}
+		w.add(step)
+		w.add(&workflowStep{
+			Name: "wait healthy",
+			Node: session.NodeName,
+			Run: func() error {
+				if err := waitHealthy(job, session, timeout); err != nil {
+					return err
+				}
+				if check != nil {
+					if err := check.Run(session); err != nil {
+						return err
+					}
+				}
+				return services.UpdateSessionDrain(session.NodeName, nil)
+			},
+		})
+	}
+	switchTo := func(from *services.Session, to *services.Session) {
+		w.add(&workflowStep{
+			Name: "switch",
+			Node: to.NodeName,
+			Run: func() error {
+				return upgradeSwitch(w, job, from, to)
+			},
+			// only switch back to a node that was rolled back and is synced again; otherwise the association
+			// stays on the upgraded node and the failed rollback alerts the collator
+			Rollback: func() error {
+				if rollback == nil {
+					return fmt.Errorf("the plan has no rollback for %s, so %s stays associated", from.NodeName, to.NodeName)
+				}
+				whosActive, err := getActiveSessions(job.sessions())
+				if err != nil {
+					return err
+				}
+				if _, err = waitForSync(job, from, whosActive); err != nil {
+					return fmt.Errorf("%v, so %s stays associated", err, to.NodeName)
+				}
+				return upgradeSwitch(w, job, to, from)
+			},
+		})
+	}
+
+	upgradeNode(standby)
+	switchTo(primary, standby)
+	upgradeNode(primary)
+	if plan.SwitchBack {
+		switchTo(standby, primary)
+	}
+	return w, nil
+}
+
+// upgradeSwitch moves the association within an upgrade workflow; a no-op if it is already on the target
+func upgradeSwitch(w *workflow, job *failoverJob, from *services.Session, to *services.Session) error {
+	whosActive, err := getActiveSessions(job.sessions())
+	if err != nil {
+		return err
+	}
+	if act, ok := whosActive[to.Session]; ok && act.Active {
+		return nil
+	}
+	if act, ok := whosActive[from.Session]; !ok || !act.Active {
+		return fmt.Errorf("%s is not associated", from.NodeName)
+	}
+	inc := &incident{
+		Detected: time.Now(),
+		Trigger:  fmt.Sprintf("%s %s", w.Name, w.ID),
+		Health:   lockedHealthSnapshot(job.sessions(), whosActive),
+	}
+	reason := fmt.Sprintf("planned switch of %s %s", w.Name, w.ID)
+	return switchOver(job, from, to, whosActive, inc, reason, nil)
+}
+
+// waitHealthy waits until a node imports new blocks and keeps up with the group for healthyChecks checks in a row
+func waitHealthy(job *failoverJob, session *services.Session, timeout time.Duration) error {
+	startTelemetry()
+	niMX.RLock()
+	start := nodeImported[session.NodeName]
+	niMX.RUnlock()
+
+	deadline := time.Now().Add(timeout)
+	passed := 0
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Duration(services.Config().BLOCK_CHECK_PERIOD_IN_SECONDS) * time.Second)
+		niMX.RLock()
+		advanced := nodeImported[session.NodeName] > start
+		niMX.RUnlock()
+		if advanced && !laggingNow(session, job.sessions()) {
+			passed++
+		} else {
+			passed = 0
+		}
+		if passed >= healthyChecks {
+			fmt.Printf("%s is healthy\n", session.NodeName)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s was not healthy within %v", session.NodeName, timeout)
+}
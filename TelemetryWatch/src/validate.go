@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+)
+
+/**
+A presigned transaction is looked up purely by node name and nonce, so a mis-keyed DB row
+would happily submit the wrong association. Before submitting, we decode the plain extrinsic and check
+that it is proxy(collator, AuthorMapping, <reassociation call of builder>) signed by the chosen proxy
+at the expected nonce, and that the inner call (updateAssociation or setKeys, whichever the tx carries)
+moves authoring from the alert session to the candidate
+**/
+func validateTransaction(tx string, proxyAccount string, sessAlert *services.Session, sessCandidate *services.Session, nonce int, collator string) error {
+	ext, err := services.DecodeExtrinsic(tx)
+	if err != nil {
+		return fmt.Errorf("cannot decode extrinsic: %v", err)
+	}
+	if !services.SameHex(ext.Signer, proxyAccount) {
+		return fmt.Errorf("signed by %s instead of proxy %s", ext.Signer, proxyAccount)
+	}
+	if ext.Nonce != nonce {
+		return fmt.Errorf("signed for nonce %d instead of %d", ext.Nonce, nonce)
+	}
+	proxy, err := ext.Call.DecodeProxy()
+	if err != nil {
+		return err
+	}
+	if collator == "" {
+		return fmt.Errorf("collator account of %s is unknown", sessAlert.NodeName)
+	}
+	if !services.SameHex(proxy.Real, collator) {
+		return fmt.Errorf("proxies for %s instead of collator %s", proxy.Real, collator)
+	}
+	if proxy.ForceProxyType != services.ProxyTypeAuthorMapping {
+		return fmt.Errorf("forces proxy type %d instead of AuthorMapping (%d)", proxy.ForceProxyType, services.ProxyTypeAuthorMapping)
+	}
+	builder, err := services.CallBuilderOf(proxy.Call)
+	if err != nil {
+		return err
+	}
+	return builder.Check(proxy.Call, sessAlert, sessCandidate)
+}
+
+// checkValidation warns at startup when presigned txs are not validated, or cannot be and will be blocked
+func checkValidation() {
+	if services.Config().SKIP_PRESIGNED_TX_VALIDATION {
+		fmt.Println("WARNING: SKIP_PRESIGNED_TX_VALIDATION is set, presigned txs are submitted without being checked")
+		return
+	}
+	keys, err := services.Keys()
+	if err == nil && keys.KeyEnv == "" {
+		err = fmt.Errorf("keyEnv is not available in the secret")
+	}
+	if err != nil {
+		fmt.Printf("WARNING: presigned txs cannot be validated, so none will be submitted: %v\n", err)
+	}
+}
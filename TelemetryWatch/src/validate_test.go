@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"movrfailover/services"
+)
+
+// the presigned extrinsic of services/extrinsic_test.go: proxy(0xaa..aa, AuthorMapping, updateAssociation(0x11..11, 0x22..22))
+var presignedTx = "0xcd0284" +
+	"9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f" +
+	"28ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276" +
+	"67cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83" + "00" +
+	"00" + "14" + "00" +
+	"1f00" + strings.Repeat("aa", 20) + "0106" +
+	"1701" + strings.Repeat("11", 32) + strings.Repeat("22", 32)
+
+func TestValidateTransaction(t *testing.T) {
+	proxy := "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"
+	collator := "0x" + strings.Repeat("aa", 20)
+	sessA := &services.Session{NodeName: "node-a", Session: "0x" + strings.Repeat("11", 32)}
+	sessB := &services.Session{NodeName: "node-b", Session: "0x" + strings.Repeat("22", 32)}
+	sessC := &services.Session{NodeName: "node-c", Session: "0x" + strings.Repeat("33", 32)}
+	noProxyType := strings.Replace(presignedTx, "0106", "00", 1)
+	noProxyType = "0xc902" + strings.TrimPrefix(noProxyType, "0xcd02") // one byte shorter: 178 bytes
+	// the same tx carrying setKeys(0x22..22 ++ 0x44..44), two bytes longer: 181 bytes; signatures are not checked
+	setKeys := "0xd502" + strings.TrimPrefix(strings.Replace(presignedTx, "1701"+strings.Repeat("11", 32)+strings.Repeat("22", 32),
+		"17040101"+strings.Repeat("22", 32)+strings.Repeat("44", 32), 1), "0xcd02")
+	sessBVrf := &services.Session{NodeName: "node-b", Session: sessB.Session, VrfKey: "0x" + strings.Repeat("44", 32)}
+	sessCVrf := &services.Session{NodeName: "node-c", Session: sessC.Session, VrfKey: "0x" + strings.Repeat("44", 32)}
+	otherCall := strings.Replace(presignedTx, "1f00"+strings.Repeat("aa", 20)+"01061701", "1f00"+strings.Repeat("aa", 20)+"01061702", 1)
+
+	cases := []struct {
+		name     string
+		tx       string
+		proxy    string
+		from     *services.Session
+		to       *services.Session
+		nonce    int
+		collator string
+		valid    bool
+	}{
+		{"matching", presignedTx, proxy, sessA, sessB, 5, collator, true},
+		{"checksum case", presignedTx, "0x9d8A62f656a8d1615C1294fd71e9CFb3E4855A4F", sessA, sessB, 5, strings.ToUpper(collator), true},
+		{"other proxy", presignedTx, "0x" + strings.Repeat("bb", 20), sessA, sessB, 5, collator, false},
+		{"other nonce", presignedTx, proxy, sessA, sessB, 6, collator, false},
+		{"other collator", presignedTx, proxy, sessA, sessB, 5, "0x" + strings.Repeat("cc", 20), false},
+		{"unknown collator", presignedTx, proxy, sessA, sessB, 5, "", false},
+		{"reversed", presignedTx, proxy, sessB, sessA, 5, collator, false},
+		{"other candidate", presignedTx, proxy, sessA, sessC, 5, collator, false},
+		{"other alert", presignedTx, proxy, sessC, sessB, 5, collator, false},
+		{"setKeys", setKeys, proxy, sessA, sessBVrf, 5, collator, true},
+		{"setKeys other keys", setKeys, proxy, sessA, sessCVrf, 5, collator, false},
+		{"setKeys without vrfKey", setKeys, proxy, sessA, sessB, 5, collator, false},
+		{"not a reassociation", otherCall, proxy, sessA, sessB, 5, collator, false},
+		{"no proxy type", noProxyType, proxy, sessA, sessB, 5, collator, false},
+		{"not an extrinsic", "0x1234", proxy, sessA, sessB, 5, collator, false},
+	}
+	for _, c := range cases {
+		err := validateTransaction(c.tx, c.proxy, c.from, c.to, c.nonce, c.collator)
+		if c.valid && err != nil {
+			t.Errorf("%s: rejected: %v", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: accepted", c.name)
+		}
+	}
+
+	// the "no proxy type" tx must fail on the proxy type, not on decoding
+	err := validateTransaction(noProxyType, proxy, sessA, sessB, 5, collator)
+	if err == nil || !strings.Contains(err.Error(), "proxy type") {
+		t.Errorf("no proxy type: %v", err)
+	}
+}
@@ -19,7 +19,18 @@ var nodeCountMX sync.RWMutex
 var nodeFinalized = map[string]int{} // nodeName -> last finalized block
 var nodeImported = map[string]int{}  // nodeName -> last imported block
 var nfMX sync.RWMutex                // mx for nodeFinalized
-var niMX sync.RWMutex                // mx for nodeImported
+var niMX sync.RWMutex                // mx for nodeImported and nodeReported
+
+var nodeReported = map[string]int64{} // nodeName -> when telemetry last reported an imported block
+var onceTelemetry sync.Once
+
+// startTelemetry follows telemetry in the background, for commands that need node heights
+func startTelemetry() {
+	onceTelemetry.Do(func() {
+		go watch()
+		go readTelemetry()
+	})
+}
 
 func readTelemetry() {
 	ws, err := wsConnect(services.Config().HOST, services.Config().TELEMETRY_ID)
@@ -135,6 +146,7 @@ func watch() {
 				if nodeImported[nodeName] < int(importedBlock) {
 					nodeImported[nodeName] = int(importedBlock)
 				}
+				nodeReported[nodeName] = time.Now().Unix()
 				niMX.Unlock()
 				//fmt.Printf("ImportedBlock: %v for %v\n", importedBlock, nodeName)
 			}
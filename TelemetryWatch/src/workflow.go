@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"movrfailover/services"
+	"time"
+)
+
+// workflowStep is one step of a workflow on a node; Rollback, if set, undoes it when a later step fails
+type workflowStep struct {
+	Name     string
+	Node     string
+	Run      func() error
+	Rollback func() error
+}
+
+/**
+workflow runs operational steps in order, journaling each of them.
+If a step fails, the completed steps are rolled back in reverse order and the workflow stops
+**/
+type workflow struct {
+	ID        string
+	Name      string
+	Collator  *services.Collator
+	GroupName string
+	Steps     []*workflowStep
+	Quiet     bool // alert only on failures, not on start and completion
+}
+
+func newWorkflow(name string, collator *services.Collator, groupName string) *workflow {
+	return &workflow{ID: services.NewJournalID(), Name: name, Collator: collator, GroupName: groupName}
+}
+
+func (w *workflow) add(step *workflowStep) {
+	w.Steps = append(w.Steps, step)
+}
+
+func (w *workflow) run() error {
+	if !w.Quiet {
+		notifyCollator(w.Collator, fmt.Sprintf(`Started %s %s of %s`, w.Name, w.ID, w.GroupName))
+	}
+	for i, step := range w.Steps {
+		fmt.Printf("[%s] %s %s\n", w.Name, step.Name, step.Node)
+		entry := w.entry(step)
+		err := step.Run()
+		entry.Outcome = services.OutcomeDone
+		if err != nil {
+			entry.Outcome = services.OutcomeFailed
+			entry.SubmitError = err.Error()
+		}
+		w.journal(entry)
+		if err == nil {
+			continue
+		}
+
+		message := fmt.Sprintf(`%s %s failed at %s %s: %v; rolling back`, w.Name, w.ID, step.Name, step.Node, err)
+		fmt.Printf("%s\n", message)
+		notifyCollator(w.Collator, message)
+		w.rollback(w.Steps[:i+1])
+		return fmt.Errorf("%s failed at %s %s: %v", w.Name, step.Name, step.Node, err)
+	}
+	if !w.Quiet {
+		notifyCollator(w.Collator, fmt.Sprintf(`Completed %s %s of %s`, w.Name, w.ID, w.GroupName))
+	}
+	return nil
+}
+
+// rollback undoes steps in reverse order, including the failed one; rollback errors are reported, not fatal
+func (w *workflow) rollback(steps []*workflowStep) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.Rollback == nil {
+			continue
+		}
+		fmt.Printf("[%s] rollback %s %s\n", w.Name, step.Name, step.Node)
+		entry := w.entry(step)
+		entry.Step = "rollback " + step.Name
+		entry.Outcome = services.OutcomeRolledBack
+		if err := step.Rollback(); err != nil {
+			entry.Outcome = services.OutcomeFailed
+			entry.SubmitError = err.Error()
+			notifyCollator(w.Collator, fmt.Sprintf(`Rollback of %s %s failed: %v`, step.Name, step.Node, err))
+		}
+		w.journal(entry)
+	}
+}
+
+func (w *workflow) entry(step *workflowStep) *services.JournalEntry {
+	return &services.JournalEntry{
+		ID:          services.NewJournalID(),
+		Kind:        services.KindWorkflow,
+		Workflow:    w.ID,
+		Step:        step.Name,
+		Collator:    w.Collator.Name,
+		GroupName:   w.GroupName,
+		Trigger:     w.Name,
+		To:          step.Node,
+		SubmittedAt: time.Now().Unix(),
+	}
+}
+
+func (w *workflow) journal(entry *services.JournalEntry) {
+	entry.CompletedAt = time.Now().Unix()
+	if err := services.Journal().Append(entry); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+}